@@ -0,0 +1,290 @@
+package alerts
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type RuleParams struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+type RuleRequest struct {
+	EventName     string  `json:"event_name" binding:"required"`
+	WindowMinutes uint32  `json:"window_minutes" binding:"required,min=1,max=1440"`
+	DropRatio     float64 `json:"drop_ratio" binding:"omitempty,gt=0,lt=1"`
+	SpikeRatio    float64 `json:"spike_ratio" binding:"omitempty,gt=1"`
+	MinBaseline   uint64  `json:"min_baseline" binding:"omitempty"`
+	WebhookURL    string  `json:"webhook_url" binding:"omitempty,url"`
+	Enabled       *bool   `json:"enabled" binding:"omitempty"`
+}
+
+type RuleResponse struct {
+	ID            string  `json:"id"`
+	EventName     string  `json:"event_name"`
+	WindowMinutes uint32  `json:"window_minutes"`
+	DropRatio     float64 `json:"drop_ratio,omitempty"`
+	SpikeRatio    float64 `json:"spike_ratio,omitempty"`
+	MinBaseline   uint64  `json:"min_baseline"`
+	WebhookURL    string  `json:"webhook_url,omitempty"`
+	Enabled       bool    `json:"enabled"`
+	UpdatedAt     int64   `json:"updated_at"`
+}
+
+type AlertsQueryParams struct {
+	Limit int `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+type AlertResponse struct {
+	ID          string  `json:"id"`
+	RuleID      string  `json:"rule_id"`
+	EventName   string  `json:"event_name"`
+	Kind        string  `json:"kind"`
+	Current     uint64  `json:"current"`
+	Baseline    float64 `json:"baseline"`
+	WindowStart int64   `json:"window_start"`
+	WindowEnd   int64   `json:"window_end"`
+	FiredAt     int64   `json:"fired_at"`
+	Delivered   bool    `json:"delivered"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (r *RuleRequest) toRule(id string) Rule {
+	rule := Rule{
+		ID:          id,
+		EventName:   r.EventName,
+		Window:      time.Duration(r.WindowMinutes) * time.Minute,
+		DropRatio:   r.DropRatio,
+		SpikeRatio:  r.SpikeRatio,
+		MinBaseline: r.MinBaseline,
+		WebhookURL:  r.WebhookURL,
+		Enabled:     true,
+	}
+	if r.Enabled != nil {
+		rule.Enabled = *r.Enabled
+	}
+	return rule
+}
+
+func toRuleResponse(rule Rule) RuleResponse {
+	return RuleResponse{
+		ID:            rule.ID,
+		EventName:     rule.EventName,
+		WindowMinutes: uint32(rule.Window / time.Minute),
+		DropRatio:     rule.DropRatio,
+		SpikeRatio:    rule.SpikeRatio,
+		MinBaseline:   rule.MinBaseline,
+		WebhookURL:    rule.WebhookURL,
+		Enabled:       rule.Enabled,
+		UpdatedAt:     rule.UpdatedAt.Unix(),
+	}
+}
+
+func (h *Handler) ListRules(c *gin.Context) {
+	rules, err := h.service.ListRules(c.Request.Context())
+	if err != nil {
+		log.Printf("failed to list alert rules: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := make([]RuleResponse, len(rules))
+	for i, rule := range rules {
+		resp[i] = toRuleResponse(rule)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) GetRule(c *gin.Context) {
+	var params RuleParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	rule, err := h.service.GetRule(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to fetch alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if rule == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "alert rule not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, toRuleResponse(*rule))
+}
+
+func (h *Handler) CreateRule(c *gin.Context) {
+	var req RuleRequest
+	if !bindRule(c, &req) {
+		return
+	}
+
+	rule, err := h.service.SaveRule(c.Request.Context(), req.toRule(""))
+	if err != nil {
+		log.Printf("failed to create alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toRuleResponse(rule))
+}
+
+func (h *Handler) PutRule(c *gin.Context) {
+	var params RuleParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	var req RuleRequest
+	if !bindRule(c, &req) {
+		return
+	}
+
+	existing, err := h.service.GetRule(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to fetch alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "alert rule not found",
+		})
+		return
+	}
+
+	rule, err := h.service.SaveRule(c.Request.Context(), req.toRule(params.ID))
+	if err != nil {
+		log.Printf("failed to update alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, toRuleResponse(rule))
+}
+
+func (h *Handler) DeleteRule(c *gin.Context) {
+	var params RuleParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.DeleteRule(c.Request.Context(), params.ID); err != nil {
+		log.Printf("failed to delete alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListAlerts(c *gin.Context) {
+	var params AlertsQueryParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	alerts, err := h.service.ListAlerts(c.Request.Context(), params.Limit)
+	if err != nil {
+		log.Printf("failed to list alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := make([]AlertResponse, len(alerts))
+	for i, a := range alerts {
+		resp[i] = AlertResponse{
+			ID:          a.ID,
+			RuleID:      a.RuleID,
+			EventName:   a.EventName,
+			Kind:        a.Kind,
+			Current:     a.Current,
+			Baseline:    a.Baseline,
+			WindowStart: a.WindowStart.Unix(),
+			WindowEnd:   a.WindowEnd.Unix(),
+			FiredAt:     a.FiredAt.Unix(),
+			Delivered:   a.Delivered,
+			Error:       a.Error,
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// bindRule binds and validates a rule body, writing the error response
+// itself. A rule must check for at least one kind of anomaly.
+func bindRule(c *gin.Context, req *RuleRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return false
+	}
+	if req.DropRatio == 0 && req.SpikeRatio == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "at least one of drop_ratio and spike_ratio is required",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *Handler) RegisterRoutes(r gin.IRoutes) {
+	r.GET("/admin/alerts", h.ListAlerts)
+	r.GET("/admin/alerts/rules", h.ListRules)
+	r.POST("/admin/alerts/rules", h.CreateRule)
+	r.GET("/admin/alerts/rules/:id", h.GetRule)
+	r.PUT("/admin/alerts/rules/:id", h.PutRule)
+	r.DELETE("/admin/alerts/rules/:id", h.DeleteRule)
+}
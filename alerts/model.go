@@ -0,0 +1,38 @@
+package alerts
+
+import "time"
+
+const (
+	KindDrop  = "drop"
+	KindSpike = "spike"
+)
+
+// Rule watches the volume of one event name. An alert fires when the count
+// over the last Window falls below DropRatio times the seasonal baseline or
+// rises above SpikeRatio times it; a zero ratio disables that check.
+// Baselines under MinBaseline are too small to judge and never fire.
+type Rule struct {
+	ID          string
+	EventName   string
+	Window      time.Duration
+	DropRatio   float64
+	SpikeRatio  float64
+	MinBaseline uint64
+	WebhookURL  string
+	Enabled     bool
+	UpdatedAt   time.Time
+}
+
+type Alert struct {
+	ID          string    `json:"id"`
+	RuleID      string    `json:"rule_id"`
+	EventName   string    `json:"event_name"`
+	Kind        string    `json:"kind"`
+	Current     uint64    `json:"current"`
+	Baseline    float64   `json:"baseline"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	FiredAt     time.Time `json:"fired_at"`
+	Delivered   bool      `json:"-"`
+	Error       string    `json:"-"`
+}
@@ -0,0 +1,69 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Receiver is a webhook endpoint that records the alerts posted to it, for
+// tests and local development. Serve it with httptest.NewServer or
+// http.ListenAndServe and point a rule's webhook_url at it.
+type Receiver struct {
+	mu       sync.Mutex
+	alerts   []Alert
+	received chan struct{}
+}
+
+func NewReceiver() *Receiver {
+	return &Receiver{
+		received: make(chan struct{}, 1),
+	}
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var alert Alert
+	if err := json.NewDecoder(req.Body).Decode(&alert); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	r.alerts = append(r.alerts, alert)
+	r.mu.Unlock()
+
+	select {
+	case r.received <- struct{}{}:
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Alerts returns the alerts received so far, oldest first.
+func (r *Receiver) Alerts() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Alert(nil), r.alerts...)
+}
+
+// Wait blocks until at least n alerts have been received or ctx is done,
+// and returns the alerts received so far.
+func (r *Receiver) Wait(ctx context.Context, n int) ([]Alert, error) {
+	for {
+		if alerts := r.Alerts(); len(alerts) >= n {
+			return alerts, nil
+		}
+		select {
+		case <-r.received:
+		case <-ctx.Done():
+			return r.Alerts(), ctx.Err()
+		}
+	}
+}
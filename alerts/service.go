@@ -0,0 +1,326 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+const week = 7 * 24 * time.Hour
+
+// evaluatorLease is the lease held by the one instance that evaluates the
+// rules.
+const evaluatorLease = "alerts_evaluator"
+
+type alertsRepository interface {
+	ListAlertRules(ctx context.Context) ([]repository.AlertRuleRow, error)
+	GetAlertRule(ctx context.Context, id string) (*repository.AlertRuleRow, error)
+	SaveAlertRule(ctx context.Context, row repository.AlertRuleRow) error
+	DeleteAlertRule(ctx context.Context, id string) error
+	CountEventWindows(ctx context.Context, eventName string, windows []repository.TimeWindow) ([]uint64, error)
+	SaveAlert(ctx context.Context, row repository.AlertRow) error
+	LastAlertFiredAt(ctx context.Context, ruleID, kind string) (time.Time, error)
+	ListAlerts(ctx context.Context, limit int) ([]repository.AlertRow, error)
+}
+
+type leaseRepository interface {
+	AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, owner string) error
+}
+
+// Service manages alert rules and periodically checks event volumes
+// against them. Every instance runs the loop, but only the one holding the
+// evaluator lease checks the rules. A rule that keeps firing is only
+// notified again once the cooldown since its last stored alert has passed.
+type Service struct {
+	repo     alertsRepository
+	leases   leaseRepository
+	notifier *Notifier
+	cfg      config.AlertsConfig
+	owner    string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewService(repo alertsRepository, leases leaseRepository, notifier *Notifier, cfg config.AlertsConfig) *Service {
+	return &Service{
+		repo:     repo,
+		leases:   leases,
+		notifier: notifier,
+		cfg:      cfg,
+		owner:    uuid.NewString(),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *Service) ListRules(ctx context.Context) ([]Rule, error) {
+	rows, err := s.repo.ListAlertRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alert rules: %w", err)
+	}
+
+	rules := make([]Rule, len(rows))
+	for i, row := range rows {
+		rules[i] = fromRow(row)
+	}
+	return rules, nil
+}
+
+func (s *Service) GetRule(ctx context.Context, id string) (*Rule, error) {
+	row, err := s.repo.GetAlertRule(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alert rule: %w", err)
+	}
+	if row == nil {
+		return nil, nil
+	}
+
+	rule := fromRow(*row)
+	return &rule, nil
+}
+
+// SaveRule creates the rule when it has no ID and replaces it otherwise.
+func (s *Service) SaveRule(ctx context.Context, rule Rule) (Rule, error) {
+	if rule.ID == "" {
+		rule.ID = uuid.NewString()
+	}
+	rule.UpdatedAt = time.Now().UTC()
+
+	if err := s.repo.SaveAlertRule(ctx, toRow(rule)); err != nil {
+		return Rule{}, fmt.Errorf("failed to save alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (s *Service) DeleteRule(ctx context.Context, id string) error {
+	if err := s.repo.DeleteAlertRule(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) ListAlerts(ctx context.Context, limit int) ([]Alert, error) {
+	rows, err := s.repo.ListAlerts(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alerts: %w", err)
+	}
+
+	alerts := make([]Alert, len(rows))
+	for i, row := range rows {
+		alerts[i] = Alert(row)
+	}
+	return alerts, nil
+}
+
+// Start evaluates the rules in the background until Close is called.
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Close stops evaluating rules, waits for a running pass to finish and
+// hands the lease on to another instance.
+func (s *Service) Close() {
+	close(s.done)
+	s.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.leases.ReleaseLease(ctx, evaluatorLease, s.owner); err != nil {
+		log.Printf("failed to release alerts evaluator lease: %v", err)
+	}
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	for {
+		if err := s.run(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("failed to evaluate alert rules: %v", err)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run evaluates the rules if this instance holds the evaluator lease,
+// claiming or renewing it first. The lease outlasts a few missed ticks, so
+// it only moves when its holder stops.
+func (s *Service) run(ctx context.Context, now time.Time) error {
+	held, err := s.leases.AcquireLease(ctx, evaluatorLease, s.owner, 3*s.cfg.Interval)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return nil
+	}
+	return s.Evaluate(ctx, now)
+}
+
+// Evaluate checks every enabled rule as of now and fires the alerts that
+// are due.
+func (s *Service) Evaluate(ctx context.Context, now time.Time) error {
+	rules, err := s.ListRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if err := s.evaluateRule(ctx, rule, now); err != nil {
+			log.Printf("failed to evaluate alert rule %s: %v", rule.ID, err)
+		}
+	}
+	return nil
+}
+
+// evaluateRule compares the rule's latest window to the same window in
+// previous weeks, so that daily and weekly cycles do not look like
+// anomalies.
+func (s *Service) evaluateRule(ctx context.Context, rule Rule, now time.Time) error {
+	end := now.Add(-s.cfg.EvaluationDelay).Truncate(time.Minute).UTC()
+	start := end.Add(-rule.Window)
+
+	windows := make([]repository.TimeWindow, 0, s.cfg.BaselineWeeks+1)
+	for i := 0; i <= s.cfg.BaselineWeeks; i++ {
+		shift := time.Duration(i) * week
+		windows = append(windows, repository.TimeWindow{Start: start.Add(-shift), End: end.Add(-shift)})
+	}
+
+	counts, err := s.repo.CountEventWindows(ctx, rule.EventName, windows)
+	if err != nil {
+		return err
+	}
+	if len(counts) < 2 {
+		return nil
+	}
+
+	var sum uint64
+	for _, c := range counts[1:] {
+		sum += c
+	}
+	baseline := float64(sum) / float64(len(counts)-1)
+	if baseline == 0 || baseline < float64(rule.MinBaseline) {
+		return nil
+	}
+
+	current := counts[0]
+	var kind string
+	switch {
+	case rule.DropRatio > 0 && float64(current) < baseline*rule.DropRatio:
+		kind = KindDrop
+	case rule.SpikeRatio > 0 && float64(current) > baseline*rule.SpikeRatio:
+		kind = KindSpike
+	default:
+		return nil
+	}
+
+	due, err := s.shouldFire(ctx, rule.ID, kind, now)
+	if err != nil {
+		return err
+	}
+	if !due {
+		return nil
+	}
+
+	alert := Alert{
+		ID:          uuid.NewString(),
+		RuleID:      rule.ID,
+		EventName:   rule.EventName,
+		Kind:        kind,
+		Current:     current,
+		Baseline:    baseline,
+		WindowStart: start,
+		WindowEnd:   end,
+		FiredAt:     now.UTC(),
+	}
+	s.deliver(ctx, rule, &alert)
+
+	if err := s.repo.SaveAlert(ctx, repository.AlertRow(alert)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// shouldFire reports whether the cooldown since the rule last fired an
+// alert of this kind has passed. The alert history is the record, so the
+// cooldown holds across restarts and when another instance takes over.
+func (s *Service) shouldFire(ctx context.Context, ruleID, kind string, now time.Time) (bool, error) {
+	last, err := s.repo.LastAlertFiredAt(ctx, ruleID, kind)
+	if err != nil {
+		return false, err
+	}
+	return last.IsZero() || now.Sub(last) >= s.cfg.Cooldown, nil
+}
+
+// deliver sends the alert to the rule's webhook, or the default one, and
+// records the outcome on the alert.
+func (s *Service) deliver(ctx context.Context, rule Rule, alert *Alert) {
+	url := rule.WebhookURL
+	if url == "" {
+		url = s.cfg.WebhookURL
+	}
+	if url == "" {
+		alert.Error = "no webhook configured"
+		return
+	}
+
+	if err := s.notifier.Notify(ctx, url, *alert); err != nil {
+		log.Printf("failed to deliver alert %s: %v", alert.ID, err)
+		alert.Error = err.Error()
+		return
+	}
+	alert.Delivered = true
+}
+
+func fromRow(row repository.AlertRuleRow) Rule {
+	return Rule{
+		ID:          row.ID,
+		EventName:   row.EventName,
+		Window:      time.Duration(row.WindowMins) * time.Minute,
+		DropRatio:   row.DropRatio,
+		SpikeRatio:  row.SpikeRatio,
+		MinBaseline: row.MinBaseline,
+		WebhookURL:  row.WebhookURL,
+		Enabled:     row.Enabled,
+		UpdatedAt:   row.UpdatedAt,
+	}
+}
+
+func toRow(rule Rule) repository.AlertRuleRow {
+	return repository.AlertRuleRow{
+		ID:          rule.ID,
+		EventName:   rule.EventName,
+		WindowMins:  uint32(rule.Window / time.Minute),
+		DropRatio:   rule.DropRatio,
+		SpikeRatio:  rule.SpikeRatio,
+		MinBaseline: rule.MinBaseline,
+		WebhookURL:  rule.WebhookURL,
+		Enabled:     rule.Enabled,
+		UpdatedAt:   rule.UpdatedAt,
+	}
+}
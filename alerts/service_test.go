@@ -0,0 +1,240 @@
+package alerts
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+// fakeAlertsRepository returns counts for the current window followed by
+// the baseline weeks, and keeps the alert history in memory.
+type fakeAlertsRepository struct {
+	mu      sync.Mutex
+	rules   []repository.AlertRuleRow
+	counts  []uint64
+	windows [][]repository.TimeWindow
+	history []repository.AlertRow
+}
+
+func (r *fakeAlertsRepository) ListAlertRules(context.Context) ([]repository.AlertRuleRow, error) {
+	return r.rules, nil
+}
+
+func (r *fakeAlertsRepository) GetAlertRule(context.Context, string) (*repository.AlertRuleRow, error) {
+	return nil, nil
+}
+
+func (r *fakeAlertsRepository) SaveAlertRule(context.Context, repository.AlertRuleRow) error {
+	return nil
+}
+
+func (r *fakeAlertsRepository) DeleteAlertRule(context.Context, string) error {
+	return nil
+}
+
+func (r *fakeAlertsRepository) CountEventWindows(_ context.Context, _ string, windows []repository.TimeWindow) ([]uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.windows = append(r.windows, windows)
+	return r.counts, nil
+}
+
+func (r *fakeAlertsRepository) SaveAlert(_ context.Context, row repository.AlertRow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history = append(r.history, row)
+	return nil
+}
+
+func (r *fakeAlertsRepository) LastAlertFiredAt(_ context.Context, ruleID, kind string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last time.Time
+	for _, row := range r.history {
+		if row.RuleID == ruleID && row.Kind == kind && row.FiredAt.After(last) {
+			last = row.FiredAt
+		}
+	}
+	return last, nil
+}
+
+func (r *fakeAlertsRepository) ListAlerts(context.Context, int) ([]repository.AlertRow, error) {
+	return r.history, nil
+}
+
+// fakeLeases grants each lease to the first owner that asks for it.
+type fakeLeases struct {
+	mu      sync.Mutex
+	holders map[string]string
+}
+
+func (l *fakeLeases) AcquireLease(_ context.Context, name, owner string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders == nil {
+		l.holders = map[string]string{}
+	}
+	if holder, ok := l.holders[name]; ok {
+		return holder == owner, nil
+	}
+	l.holders[name] = owner
+	return true, nil
+}
+
+func (l *fakeLeases) ReleaseLease(_ context.Context, name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders[name] == owner {
+		delete(l.holders, name)
+	}
+	return nil
+}
+
+var testConfig = config.AlertsConfig{
+	Enabled:         true,
+	Interval:        5 * time.Minute,
+	EvaluationDelay: 2 * time.Minute,
+	BaselineWeeks:   4,
+	Cooldown:        time.Hour,
+	WebhookTimeout:  time.Second,
+}
+
+var testRule = Rule{
+	ID:          "rule-1",
+	EventName:   "purchase",
+	Window:      15 * time.Minute,
+	DropRatio:   0.5,
+	SpikeRatio:  2,
+	MinBaseline: 10,
+	Enabled:     true,
+}
+
+func newTestService(t *testing.T, repo *fakeAlertsRepository) (*Service, *Receiver) {
+	t.Helper()
+	receiver := NewReceiver()
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	cfg := testConfig
+	cfg.WebhookURL = server.URL
+	return NewService(repo, &fakeLeases{}, NewNotifier(cfg.WebhookTimeout), cfg), receiver
+}
+
+func TestEvaluateRuleBaseline(t *testing.T) {
+	repo := &fakeAlertsRepository{counts: []uint64{100, 90, 100, 110, 100}}
+	service, _ := newTestService(t, repo)
+	now := time.Date(2024, 3, 8, 12, 0, 30, 0, time.UTC)
+
+	if err := service.evaluateRule(context.Background(), testRule, now); err != nil {
+		t.Fatalf("evaluateRule() error = %v", err)
+	}
+
+	windows := repo.windows[0]
+	if len(windows) != 5 {
+		t.Fatalf("counted %d windows, want the current one and 4 baseline weeks", len(windows))
+	}
+	end := time.Date(2024, 3, 8, 11, 58, 0, 0, time.UTC)
+	if windows[0].End != end || windows[0].Start != end.Add(-15*time.Minute) {
+		t.Errorf("current window = %+v, want 15m ending at %v", windows[0], end)
+	}
+	if windows[4].End != end.Add(-4*week) {
+		t.Errorf("last baseline window ends %v, want %v", windows[4].End, end.Add(-4*week))
+	}
+	if len(repo.history) != 0 {
+		t.Errorf("fired %d alerts for a normal count", len(repo.history))
+	}
+}
+
+func TestEvaluateRuleFires(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []uint64
+		want   string
+	}{
+		{"drop", []uint64{40, 100, 100, 100, 100}, KindDrop},
+		{"spike", []uint64{250, 100, 100, 100, 100}, KindSpike},
+		{"within ratios", []uint64{60, 100, 100, 100, 100}, ""},
+		{"baseline under minimum", []uint64{0, 8, 8, 8, 8}, ""},
+		{"no baseline", []uint64{50, 0, 0, 0, 0}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeAlertsRepository{counts: tt.counts}
+			service, receiver := newTestService(t, repo)
+
+			if err := service.evaluateRule(context.Background(), testRule, time.Now()); err != nil {
+				t.Fatalf("evaluateRule() error = %v", err)
+			}
+
+			if tt.want == "" {
+				if len(repo.history) != 0 {
+					t.Errorf("fired %+v, want no alert", repo.history)
+				}
+				return
+			}
+			if len(repo.history) != 1 || repo.history[0].Kind != tt.want || !repo.history[0].Delivered {
+				t.Fatalf("history = %+v, want one delivered %s alert", repo.history, tt.want)
+			}
+			received := receiver.Alerts()
+			if len(received) != 1 || received[0].Kind != tt.want || received[0].Baseline != 100 {
+				t.Errorf("received %+v, want one %s alert with baseline 100", received, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateRuleCooldown(t *testing.T) {
+	repo := &fakeAlertsRepository{counts: []uint64{40, 100, 100, 100, 100}}
+	service, receiver := newTestService(t, repo)
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+
+	for _, at := range []time.Time{now, now.Add(30 * time.Minute), now.Add(time.Hour)} {
+		if err := service.evaluateRule(context.Background(), testRule, at); err != nil {
+			t.Fatalf("evaluateRule() error = %v", err)
+		}
+	}
+	if got := len(receiver.Alerts()); got != 2 {
+		t.Errorf("sent %d alerts, want 2 with one held back by the cooldown", got)
+	}
+
+	// Another instance reads the same history, so it respects the cooldown.
+	other, otherReceiver := newTestService(t, repo)
+	if err := other.evaluateRule(context.Background(), testRule, now.Add(90*time.Minute)); err != nil {
+		t.Fatalf("evaluateRule() error = %v", err)
+	}
+	if got := len(otherReceiver.Alerts()); got != 0 {
+		t.Errorf("other instance sent %d alerts within the cooldown", got)
+	}
+}
+
+func TestEvaluatorRunsOnLeaseHolder(t *testing.T) {
+	repo := &fakeAlertsRepository{
+		rules:  []repository.AlertRuleRow{toRow(testRule)},
+		counts: []uint64{100, 100, 100, 100, 100},
+	}
+	leases := &fakeLeases{}
+	first := NewService(repo, leases, NewNotifier(time.Second), testConfig)
+	second := NewService(repo, leases, NewNotifier(time.Second), testConfig)
+
+	for _, service := range []*Service{first, second} {
+		if err := service.run(context.Background(), time.Now()); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+	if len(repo.windows) != 1 {
+		t.Fatalf("evaluated %d times, want once by the lease holder", len(repo.windows))
+	}
+
+	first.Close()
+	if err := second.run(context.Background(), time.Now()); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(repo.windows) != 2 {
+		t.Errorf("evaluated %d times, want 2 after handover", len(repo.windows))
+	}
+}
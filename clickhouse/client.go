@@ -3,6 +3,7 @@ package clickhouse
 import (
 	"context"
 	"fmt"
+	"reflect"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
@@ -14,8 +15,10 @@ type Client struct {
 	conn driver.Conn
 }
 
-func NewClient(cfg config.ClickHouseConfig) (*Client, error) {
-	conn, err := clickhouse.Open(&clickhouse.Options{
+// options passes credentials as fields rather than in a DSN, so they never
+// end up in a logged connection string.
+func options(cfg config.ClickHouseConfig) *clickhouse.Options {
+	return &clickhouse.Options{
 		Addr: []string{fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)},
 		Auth: clickhouse.Auth{
 			Database: cfg.Database,
@@ -28,7 +31,11 @@ func NewClient(cfg config.ClickHouseConfig) (*Client, error) {
 		Compression: &clickhouse.Compression{
 			Method: clickhouse.CompressionLZ4,
 		},
-	})
+	}
+}
+
+func NewClient(cfg config.ClickHouseConfig) (*Client, error) {
+	conn, err := clickhouse.Open(options(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to clickhouse: %w", err)
 	}
@@ -40,6 +47,45 @@ func (c *Client) Conn() driver.Conn {
 	return c.conn
 }
 
+// QueryReadOnly runs an ad hoc query with readonly=2, which rejects writes
+// and schema changes, and returns the column names and rows. Values have
+// the Go types the driver scans each column into.
+func (c *Client) QueryReadOnly(ctx context.Context, query string) ([]string, [][]any, error) {
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"readonly": 2,
+	}))
+
+	rows, err := c.conn.Query(ctx, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	columnTypes := rows.ColumnTypes()
+	var results [][]any
+	for rows.Next() {
+		dest := make([]any, len(columnTypes))
+		for i, ct := range columnTypes {
+			dest[i] = reflect.New(ct.ScanType()).Interface()
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		row := make([]any, len(dest))
+		for i, d := range dest {
+			row[i] = reflect.ValueOf(d).Elem().Interface()
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return rows.Columns(), results, nil
+}
+
 func (c *Client) Ping(ctx context.Context) error {
 	return c.conn.Ping(ctx)
 }
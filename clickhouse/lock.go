@@ -0,0 +1,258 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+)
+
+const (
+	migrationLockTable = "schema_migrations_lock"
+	migrationLockName  = "migrations"
+
+	// lockSettleDelay gives inserts by competing instances time to become
+	// visible before the oldest claim is read back.
+	lockSettleDelay = 500 * time.Millisecond
+	lockRetryDelay  = 2 * time.Second
+)
+
+// ClickHouse has no advisory locks or compare-and-set, so the lock is a
+// lease: every instance inserts a claim, waits for competing claims to
+// land, and the oldest live claim wins. The holder renews its claim while
+// it runs; a claim that is not renewed expires, so a crashed instance
+// cannot block migrations for longer than the TTL. On a cluster the table is
+// replicated across every node under one path, and claims are written and
+// read with a quorum so that every instance sees the same claims whichever
+// shard it is connected to.
+const createLockTable = `
+CREATE TABLE IF NOT EXISTS %s%s (
+    name        String,
+    owner       String,
+    holder      String,
+    acquired_at DateTime64(6),
+    expires_at  DateTime64(6),
+    released    UInt8,
+    version     UInt64
+)
+ENGINE = %s
+ORDER BY (name, owner)
+TTL toDateTime(expires_at) + INTERVAL 1 DAY`
+
+// LockHolder describes the instance holding the migration lock.
+type LockHolder struct {
+	Holder     string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+type migrationLock struct {
+	db      *sql.DB
+	schema  repository.Schema
+	table   string
+	ttl     time.Duration
+	timeout time.Duration
+	owner   string
+	holder  string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newMigrationLock(ctx context.Context, db *sql.DB, schema repository.Schema, ttl, timeout time.Duration) (*migrationLock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("migration lock TTL must be positive")
+	}
+	table := schema.Local(migrationLockTable)
+	query := fmt.Sprintf(createLockTable, table, schema.OnCluster(), schema.SharedEngine(migrationLockTable, "ReplacingMergeTree(version)"))
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return nil, fmt.Errorf("failed to create migration lock table: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	return &migrationLock{
+		db:      db,
+		schema:  schema,
+		table:   table,
+		ttl:     ttl,
+		timeout: timeout,
+		owner:   uuid.NewString(),
+		holder:  fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+	}, nil
+}
+
+// Acquire waits until this instance holds the lock, and keeps renewing it
+// until Release.
+func (l *migrationLock) Acquire(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	for {
+		current, err := l.tryAcquire(ctx)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			break
+		}
+
+		log.Printf("waiting for migration lock held by %s since %s", current.Holder, current.AcquiredAt.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out waiting for migration lock held by %s", current.Holder)
+			}
+			return ctx.Err()
+		case <-time.After(lockRetryDelay):
+		}
+	}
+
+	l.done = make(chan struct{})
+	l.wg.Add(1)
+	go l.renewLoop()
+	return nil
+}
+
+// tryAcquire claims the lock and returns nil if the claim won, or the
+// current holder after withdrawing the claim if it lost.
+func (l *migrationLock) tryAcquire(ctx context.Context) (*LockHolder, error) {
+	_, err := l.db.ExecContext(l.consistent(ctx), `
+		INSERT INTO `+l.table+`
+		SELECT ?, ?, ?, now64(6), now64(6) + toIntervalMillisecond(?), 0, ?`,
+		migrationLockName, l.owner, l.holder, l.ttl.Milliseconds(), time.Now().UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim migration lock: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(lockSettleDelay):
+	}
+
+	var owner string
+	var current LockHolder
+	err = l.db.QueryRowContext(l.consistent(ctx), `
+		SELECT owner, holder, acquired_at, expires_at
+		FROM `+l.table+` FINAL
+		WHERE name = ? AND released = 0 AND expires_at > now64(6)
+		ORDER BY acquired_at, owner
+		LIMIT 1`,
+		migrationLockName,
+	).Scan(&owner, &current.Holder, &current.AcquiredAt, &current.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration lock: %w", err)
+	}
+	if owner == l.owner {
+		return nil, nil
+	}
+
+	if err := l.update(ctx, 1); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+func (l *migrationLock) renewLoop() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			if err := l.update(ctx, 0); err != nil {
+				log.Printf("failed to renew migration lock: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Release stops renewing the lock and gives it up.
+func (l *migrationLock) Release(ctx context.Context) error {
+	if l.done != nil {
+		close(l.done)
+		l.wg.Wait()
+		l.done = nil
+	}
+	return l.update(ctx, 1)
+}
+
+// update rewrites this instance's claim with a fresh expiry, marking it
+// released or not.
+func (l *migrationLock) update(ctx context.Context, released uint8) error {
+	_, err := l.db.ExecContext(l.consistent(ctx), `
+		INSERT INTO `+l.table+`
+		SELECT name, owner, holder, acquired_at, now64(6) + toIntervalMillisecond(?), ?, ?
+		FROM `+l.table+` FINAL
+		WHERE name = ? AND owner = ?`,
+		l.ttl.Milliseconds(), released, time.Now().UnixNano(), migrationLockName, l.owner,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update migration lock: %w", err)
+	}
+	return nil
+}
+
+// consistent makes inserts wait for a quorum of replicas and reads see
+// every insert that reached one, when running on a cluster.
+func (l *migrationLock) consistent(ctx context.Context) context.Context {
+	if l.schema.Cluster == "" {
+		return ctx
+	}
+	return clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"insert_quorum":                 "auto",
+		"select_sequential_consistency": 1,
+	}))
+}
+
+// Holder returns the instance holding the lock, or nil if it is free.
+func (l *migrationLock) Holder(ctx context.Context) (*LockHolder, error) {
+	var current LockHolder
+	err := l.db.QueryRowContext(l.consistent(ctx), `
+		SELECT holder, acquired_at, expires_at
+		FROM `+l.table+` FINAL
+		WHERE name = ? AND released = 0 AND expires_at > now64(6)
+		ORDER BY acquired_at, owner
+		LIMIT 1`,
+		migrationLockName,
+	).Scan(&current.Holder, &current.AcquiredAt, &current.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration lock: %w", err)
+	}
+	return &current, nil
+}
+
+// Clear releases every claim, for recovering from a holder that is known
+// to be gone without waiting for its claim to expire.
+func (l *migrationLock) Clear(ctx context.Context) error {
+	_, err := l.db.ExecContext(l.consistent(ctx), `
+		INSERT INTO `+l.table+`
+		SELECT name, owner, holder, acquired_at, expires_at, 1, ?
+		FROM `+l.table+` FINAL
+		WHERE name = ? AND released = 0`,
+		time.Now().UnixNano(), migrationLockName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear migration lock: %w", err)
+	}
+	return nil
+}
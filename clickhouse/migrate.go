@@ -1,40 +1,461 @@
 package clickhouse
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+	"text/template"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/clickhouse"
+	migrateclickhouse "github.com/golang-migrate/migrate/v4/database/clickhouse"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 
+	"github.com/insider/event-ingestion/clickhouse/repository"
 	"github.com/insider/event-ingestion/config"
 )
 
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
-func RunMigrations(cfg config.ClickHouseConfig) error {
-	dsn := fmt.Sprintf(
-		"clickhouse://%s:%d?database=%s&username=%s&password=%s&x-multi-statement=true",
-		cfg.Host, cfg.Port, cfg.Database, cfg.Username, cfg.Password,
-	)
+// versionTableEngine is the engine golang-migrate creates its table with on
+// a single server. On a cluster the table is replicated across every node
+// under one path, so all shards see the same version.
+const versionTableEngine = "TinyLog"
 
-	sourceDriver, err := iofs.New(migrationsFS, "migrations")
+// createVersionTable matches the table golang-migrate creates, but with IF
+// NOT EXISTS so that instances starting together do not race to create it.
+const createVersionTable = `
+CREATE TABLE IF NOT EXISTS %s%s (
+    version    Int64,
+    dirty      UInt8,
+    sequence   UInt64
+)
+ENGINE = %s`
+
+// Migration is one embedded schema migration.
+type Migration struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// MigrationStatus describes the schema version of the database. Dirty
+// means the migration to Version failed part way and has to be fixed by
+// hand and then forced. Lock is the instance migrating, if any.
+type MigrationStatus struct {
+	Version    uint
+	Dirty      bool
+	Migrations []Migration
+	Lock       *LockHolder
+}
+
+// MigrationStep is one migration that a run would apply, or roll back when
+// Up is false, with the SQL it would execute.
+type MigrationStep struct {
+	Version uint
+	Name    string
+	Up      bool
+	SQL     string
+}
+
+// Migrator applies the embedded migrations to the configured database.
+// Every change runs under a lock held in ClickHouse, so only one instance
+// migrates at a time. The migrations are templates, rendered with the
+// database, cluster and Kafka settings they are applied with.
+type Migrator struct {
+	source   source.Driver
+	migrator *migrate.Migrate
+	lock     *migrationLock
+}
+
+func NewMigrator(cfg config.ClickHouseConfig, kafkaCfg config.KafkaConfig) (*Migrator, error) {
+	schema := repository.Schema{Database: cfg.Database, Cluster: cfg.Cluster}
+
+	// The connection is opened from options rather than a DSN, so the
+	// password never appears in a URL that could be logged.
+	db := clickhouse.OpenDB(options(cfg))
+
+	engine := versionTableEngine
+	if cfg.Cluster != "" {
+		engine = schema.SharedEngine(migrateclickhouse.DefaultMigrationsTable, "MergeTree() ORDER BY sequence")
+	}
+
+	ctx := context.Background()
+	query := fmt.Sprintf(createVersionTable, schema.Local(migrateclickhouse.DefaultMigrationsTable), schema.OnCluster(), engine)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	lock, err := newMigrationLock(ctx, db, schema, cfg.MigrationLockTTL, cfg.MigrationLockTimeout)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	databaseDriver, err := migrateclickhouse.WithInstance(db, &migrateclickhouse.Config{
+		DatabaseName:          cfg.Database,
+		ClusterName:           cfg.Cluster,
+		MigrationsTableEngine: engine,
+		MultiStatementEnabled: true,
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create database driver: %w", err)
+	}
+
+	files, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		databaseDriver.Close()
+		return nil, fmt.Errorf("failed to create source driver: %w", err)
+	}
+
+	brokers := cfg.KafkaBrokers
+	if len(brokers) == 0 {
+		brokers = kafkaCfg.Brokers
+	}
+	sourceDriver := &templateSource{
+		Driver: files,
+		data: migrationData{
+			Schema:              schema,
+			Brokers:             strings.Join(brokers, ","),
+			Topic:               kafkaCfg.Topic,
+			LateTopic:           kafkaCfg.LateTopic,
+			RoutedTopics:        kafkaCfg.RoutedTopics,
+			ConsumerGroupPrefix: cfg.ConsumerGroupPrefix,
+		},
+	}
+
+	migrator, err := migrate.NewWithInstance("iofs", sourceDriver, "clickhouse", databaseDriver)
+	if err != nil {
+		sourceDriver.Close()
+		databaseDriver.Close()
+		return nil, fmt.Errorf("failed to create migrator: %w", err)
+	}
+	migrator.Log = migrateLogger{}
+
+	return &Migrator{source: sourceDriver, migrator: migrator, lock: lock}, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func() error {
+		if err := m.migrator.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// Steps applies n pending migrations, or rolls back -n applied ones when n
+// is negative.
+func (m *Migrator) Steps(ctx context.Context, n int) error {
+	return m.locked(ctx, func() error {
+		if err := m.migrator.Steps(n); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// Down rolls back every applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func() error {
+		if err := m.migrator.Down(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// Goto applies or rolls back migrations until the schema is at version.
+// Version zero rolls back every migration.
+func (m *Migrator) Goto(ctx context.Context, version uint) error {
+	if version == 0 {
+		return m.Down(ctx)
+	}
+	return m.locked(ctx, func() error {
+		if err := m.migrator.Migrate(version); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// Force sets the schema version and clears the dirty flag without running
+// any migration.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	return m.locked(ctx, func() error {
+		if err := m.migrator.Force(version); err != nil {
+			return fmt.Errorf("failed to force migration version: %w", err)
+		}
+		return nil
+	})
+}
+
+// Unlock releases the migration lock whoever holds it. It is only needed
+// when a migrating instance died and waiting for its lock to expire is not
+// an option.
+func (m *Migrator) Unlock(ctx context.Context) error {
+	return m.lock.Clear(ctx)
+}
+
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	if err := m.lock.Acquire(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.lock.Release(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("failed to release migration lock: %v", err)
+		}
+	}()
+
+	return fn()
+}
+
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	var status MigrationStatus
+
+	version, dirty, err := m.version()
+	if err != nil {
+		return status, err
+	}
+	status.Version = version
+	status.Dirty = dirty
+
+	versions, err := m.versions()
+	if err != nil {
+		return status, err
+	}
+	for _, v := range versions {
+		name, err := m.migrationName(v)
+		if err != nil {
+			return status, err
+		}
+		status.Migrations = append(status.Migrations, Migration{
+			Version: v,
+			Name:    name,
+			Applied: v <= status.Version && !(v == status.Version && dirty),
+		})
+	}
+
+	status.Lock, err = m.lock.Holder(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	return status, nil
+}
+
+// Target returns the version that applying n migrations, or rolling back
+// -n when n is negative, would reach. Zero targets the latest migration.
+func (m *Migrator) Target(n int) (uint, error) {
+	current, _, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+	versions, err := m.versions()
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	if n == 0 {
+		return versions[len(versions)-1], nil
+	}
+
+	// Position of the current version, or of the last migration before it.
+	i := -1
+	for i+1 < len(versions) && versions[i+1] <= current {
+		i++
+	}
+
+	i = max(-1, min(i+n, len(versions)-1))
+	if i < 0 {
+		return 0, nil
+	}
+	return versions[i], nil
+}
+
+// Plan returns the migrations that moving the schema to version would run,
+// in the order they would run, without running them. Version zero rolls
+// back every migration.
+func (m *Migrator) Plan(version uint) ([]MigrationStep, error) {
+	current, dirty, err := m.version()
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("schema is dirty at version %d; fix it by hand and force the version first", current)
+	}
+	versions, err := m.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []MigrationStep
+	if version >= current {
+		for _, v := range versions {
+			if v > current && v <= version {
+				steps = append(steps, MigrationStep{Version: v, Up: true})
+			}
+		}
+	} else {
+		for i := len(versions) - 1; i >= 0; i-- {
+			if v := versions[i]; v > version && v <= current {
+				steps = append(steps, MigrationStep{Version: v})
+			}
+		}
+	}
+
+	for i := range steps {
+		read := m.source.ReadDown
+		if steps[i].Up {
+			read = m.source.ReadUp
+		}
+		r, name, err := read(steps[i].Version)
+		if errors.Is(err, fs.ErrNotExist) {
+			// A migration without a down file is rolled back by doing nothing.
+			steps[i].Name, err = m.migrationName(steps[i].Version)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %d: %w", steps[i].Version, err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %d: %w", steps[i].Version, err)
+		}
+		steps[i].Name = name
+		steps[i].SQL = string(body)
+	}
+
+	return steps, nil
+}
+
+// version returns the schema version, which is zero before the first
+// migration.
+func (m *Migrator) version() (uint, bool, error) {
+	version, dirty, err := m.migrator.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+// versions returns the versions of the embedded migrations in order.
+func (m *Migrator) versions() ([]uint, error) {
+	var versions []uint
+	v, err := m.source.First()
+	for err == nil {
+		versions = append(versions, v)
+		v, err = m.source.Next(v)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	return versions, nil
+}
+
+func (m *Migrator) migrationName(version uint) (string, error) {
+	r, name, err := m.source.ReadUp(version)
 	if err != nil {
-		return fmt.Errorf("failed to create source driver: %w", err)
+		return "", fmt.Errorf("failed to read migration %d: %w", version, err)
 	}
+	r.Close()
+	return name, nil
+}
+
+func (m *Migrator) Close() error {
+	sourceErr, dbErr := m.migrator.Close()
+	return errors.Join(sourceErr, dbErr)
+}
 
-	migrator, err := migrate.NewWithSourceInstance("iofs", sourceDriver, dsn)
+// RunMigrations applies all pending migrations, waiting for any other
+// instance that is migrating to finish first.
+func RunMigrations(ctx context.Context, cfg config.ClickHouseConfig, kafkaCfg config.KafkaConfig) error {
+	migrator, err := NewMigrator(cfg, kafkaCfg)
 	if err != nil {
-		return fmt.Errorf("failed to create migrator: %w", err)
+		return err
 	}
 	defer migrator.Close()
 
-	if err := migrator.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		return fmt.Errorf("migration failed: %w", err)
+	return migrator.Up(ctx)
+}
+
+// migrationData is what the migration files are rendered with. Database,
+// Cluster, OnCluster and Engine come from the schema. Each routed topic gets
+// a Kafka table of its own.
+type migrationData struct {
+	repository.Schema
+	Brokers             string
+	Topic               string
+	LateTopic           string
+	RoutedTopics        []config.KafkaRoutedTopic
+	ConsumerGroupPrefix string
+}
+
+// templateSource renders each migration file as a text/template before it
+// is run or shown.
+type templateSource struct {
+	source.Driver
+	data migrationData
+}
+
+func (s *templateSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	r, identifier, err := s.Driver.ReadUp(version)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.render(version, r, identifier)
+}
+
+func (s *templateSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	r, identifier, err := s.Driver.ReadDown(version)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.render(version, r, identifier)
+}
+
+func (s *templateSource) render(version uint, r io.ReadCloser, identifier string) (io.ReadCloser, string, error) {
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
 	}
+	tmpl, err := template.New(identifier).Parse(string(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse migration %d: %w", version, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, s.data); err != nil {
+		return nil, "", fmt.Errorf("failed to render migration %d: %w", version, err)
+	}
+	return io.NopCloser(&buf), identifier, nil
+}
+
+// migrateLogger logs each migration as it is applied or rolled back.
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...any) {
+	log.Printf("migrate: "+format, v...)
+}
 
-	return nil
+func (migrateLogger) Verbose() bool {
+	return false
 }
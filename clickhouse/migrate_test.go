@@ -0,0 +1,125 @@
+package clickhouse
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+func newTestSource(t *testing.T, schema repository.Schema) *templateSource {
+	t.Helper()
+	files, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		t.Fatalf("iofs.New() error = %v", err)
+	}
+	t.Cleanup(func() { files.Close() })
+	return &templateSource{
+		Driver: files,
+		data: migrationData{
+			Schema:    schema,
+			Brokers:   "localhost:9092",
+			Topic:     "events",
+			LateTopic: "events.late",
+			RoutedTopics: []config.KafkaRoutedTopic{
+				{Name: "transactional", MaxBlockSize: 1024, NumConsumers: 1, PollTimeout: 500 * time.Millisecond, FlushInterval: 500 * time.Millisecond},
+				{Name: "orders", MaxBlockSize: 4096, NumConsumers: 3, PollTimeout: 250 * time.Millisecond, FlushInterval: 2 * time.Second},
+			},
+			ConsumerGroupPrefix: "clickhouse_events",
+		},
+	}
+}
+
+func readUp(t *testing.T, s *templateSource, version uint) string {
+	t.Helper()
+	r, _, err := s.ReadUp(version)
+	if err != nil {
+		t.Fatalf("ReadUp(%d) error = %v", version, err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading migration %d: %v", version, err)
+	}
+	return string(body)
+}
+
+func TestMigrationsRender(t *testing.T) {
+	for _, schema := range []repository.Schema{
+		{Database: "analytics"},
+		{Database: "analytics", Cluster: "events"},
+	} {
+		s := newTestSource(t, schema)
+		version, err := s.First()
+		for err == nil {
+			if body := readUp(t, s, version); strings.Contains(body, "{{") {
+				t.Errorf("migration %d has unrendered template actions", version)
+			}
+			if _, _, err := s.ReadDown(version); err != nil {
+				t.Errorf("ReadDown(%d) error = %v", version, err)
+			}
+			version, err = s.Next(version)
+		}
+	}
+}
+
+func TestRoutedTopicsGetKafkaTables(t *testing.T) {
+	s := newTestSource(t, repository.Schema{Database: "analytics"})
+
+	for _, version := range []uint{10, 11} {
+		body := readUp(t, s, version)
+		for _, want := range []string{
+			"CREATE TABLE IF NOT EXISTS analytics.events_orders_kafka (",
+			"kafka_topic_list = 'events.orders'",
+			"kafka_group_name = 'clickhouse_events_orders_consumer'",
+			"kafka_max_block_size = 4096",
+			"kafka_num_consumers = 3",
+			"kafka_poll_timeout_ms = 250",
+			"kafka_flush_interval_ms = 2000",
+			"CREATE MATERIALIZED VIEW IF NOT EXISTS analytics.events_orders_kafka_mv\nTO analytics.events AS",
+			"CREATE TABLE IF NOT EXISTS analytics.events_transactional_kafka (",
+			"kafka_max_block_size = 1024",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("migration %d is missing %q", version, want)
+			}
+		}
+		if strings.Contains(body, "behavioral") {
+			t.Errorf("migration %d creates a Kafka table for a topic that is not configured", version)
+		}
+	}
+
+	r, _, err := s.ReadDown(10)
+	if err != nil {
+		t.Fatalf("ReadDown(10) error = %v", err)
+	}
+	body, _ := io.ReadAll(r)
+	if !strings.Contains(string(body), "DROP TABLE IF EXISTS analytics.events_orders_kafka;") {
+		t.Error("rolling back migration 10 does not drop the routed Kafka tables")
+	}
+}
+
+func TestRollupsBackfillWhileKafkaIsStopped(t *testing.T) {
+	body := readUp(t, newTestSource(t, repository.Schema{Database: "analytics"}), 2)
+
+	// The views must roll up every inserted row, however old its
+	// timestamp, so late and replayed events are counted.
+	if strings.Contains(body, "WHERE") {
+		t.Error("rollup migration filters rows")
+	}
+
+	stop := strings.Index(body, "DROP VIEW IF EXISTS analytics.events_kafka_mv")
+	views := strings.Index(body, "CREATE MATERIALIZED VIEW IF NOT EXISTS analytics.events_rollup_1m_mv")
+	backfill := strings.Index(body, "INSERT INTO analytics.events_rollup_1m")
+	restart := strings.Index(body, "CREATE MATERIALIZED VIEW IF NOT EXISTS analytics.events_kafka_mv")
+	if stop < 0 || views < 0 || backfill < 0 || restart < 0 {
+		t.Fatalf("missing statements: stop %d, views %d, backfill %d, restart %d", stop, views, backfill, restart)
+	}
+	if !(stop < views && views < backfill && backfill < restart) {
+		t.Errorf("statements out of order: stop %d, views %d, backfill %d, restart %d", stop, views, backfill, restart)
+	}
+}
@@ -1,4 +1,4 @@
-DROP VIEW IF EXISTS events_db.events_kafka_mv;
-DROP TABLE IF EXISTS events_db.events_kafka;
-DROP TABLE IF EXISTS events_db.events;
-DROP DATABASE IF EXISTS events_db;
+DROP VIEW IF EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_kafka{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events{{.OnCluster}};
+DROP DATABASE IF EXISTS {{.Database}}{{.OnCluster}};
@@ -1,6 +1,6 @@
-CREATE DATABASE IF NOT EXISTS events_db;
+CREATE DATABASE IF NOT EXISTS {{.Database}}{{.OnCluster}};
 
-CREATE TABLE IF NOT EXISTS events_db.events (
+CREATE TABLE IF NOT EXISTS {{.Database}}.events{{.OnCluster}} (
     event_hash    UInt64,
     event_name    LowCardinality(String),
     channel       LowCardinality(String),
@@ -10,11 +10,11 @@ CREATE TABLE IF NOT EXISTS events_db.events (
     tags          Array(String),
     metadata      String
 )
-ENGINE = ReplacingMergeTree()
+ENGINE = {{.Engine "ReplacingMergeTree()"}}
 PARTITION BY toYYYYMMDD(timestamp)
 ORDER BY (event_name, timestamp, channel, event_hash);
 
-CREATE TABLE IF NOT EXISTS events_db.events_kafka (
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_kafka{{.OnCluster}} (
     event_hash    UInt64,
     event_name    String,
     channel       String,
@@ -26,14 +26,14 @@ CREATE TABLE IF NOT EXISTS events_db.events_kafka (
 )
 ENGINE = Kafka()
 SETTINGS
-    kafka_broker_list = 'redpanda:9092',
-    kafka_topic_list = 'events',
-    kafka_group_name = 'clickhouse_events_consumer',
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.Topic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_consumer',
     kafka_format = 'JSONEachRow',
     kafka_max_block_size = 65536;
 
-CREATE MATERIALIZED VIEW IF NOT EXISTS events_db.events_kafka_mv
-TO events_db.events AS
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events AS
 SELECT
     event_hash,
     event_name,
@@ -43,4 +43,4 @@ SELECT
     fromUnixTimestamp(timestamp) AS timestamp,
     tags,
     metadata
-FROM events_db.events_kafka;
+FROM {{.Database}}.events_kafka;
@@ -0,0 +1,6 @@
+DROP VIEW IF EXISTS {{.Database}}.events_rollup_1d_mv{{.OnCluster}};
+DROP VIEW IF EXISTS {{.Database}}.events_rollup_1h_mv{{.OnCluster}};
+DROP VIEW IF EXISTS {{.Database}}.events_rollup_1m_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_rollup_1d{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_rollup_1h{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_rollup_1m{{.OnCluster}};
@@ -0,0 +1,102 @@
+-- Stop consuming from Kafka while the rollups are backfilled. Events sent
+-- meanwhile wait in the topic, since the consumer group's offsets are kept,
+-- and are stored once the view is created again at the end.
+DROP VIEW IF EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_rollup_1m{{.OnCluster}} (
+    event_name    LowCardinality(String),
+    channel       LowCardinality(String),
+    bucket        DateTime,
+    total_count   SimpleAggregateFunction(sum, UInt64),
+    unique_users  AggregateFunction(uniq, String)
+)
+ENGINE = {{.Engine "AggregatingMergeTree()"}}
+PARTITION BY toYYYYMMDD(bucket)
+ORDER BY (event_name, bucket, channel);
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_rollup_1h{{.OnCluster}} (
+    event_name    LowCardinality(String),
+    channel       LowCardinality(String),
+    bucket        DateTime,
+    total_count   SimpleAggregateFunction(sum, UInt64),
+    unique_users  AggregateFunction(uniq, String)
+)
+ENGINE = {{.Engine "AggregatingMergeTree()"}}
+PARTITION BY toYYYYMM(bucket)
+ORDER BY (event_name, bucket, channel);
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_rollup_1d{{.OnCluster}} (
+    event_name    LowCardinality(String),
+    channel       LowCardinality(String),
+    bucket        DateTime,
+    total_count   SimpleAggregateFunction(sum, UInt64),
+    unique_users  AggregateFunction(uniq, String)
+)
+ENGINE = {{.Engine "AggregatingMergeTree()"}}
+PARTITION BY toYear(bucket)
+ORDER BY (event_name, bucket, channel);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_rollup_1m_mv{{.OnCluster}}
+TO {{.Database}}.events_rollup_1m AS
+SELECT
+    event_name,
+    channel,
+    toStartOfMinute(timestamp) AS bucket,
+    count() AS total_count,
+    uniqState(user_id) AS unique_users
+FROM {{.Database}}.events
+GROUP BY event_name, channel, bucket;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_rollup_1h_mv{{.OnCluster}}
+TO {{.Database}}.events_rollup_1h AS
+SELECT
+    event_name,
+    channel,
+    toStartOfHour(timestamp) AS bucket,
+    count() AS total_count,
+    uniqState(user_id) AS unique_users
+FROM {{.Database}}.events
+GROUP BY event_name, channel, bucket;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_rollup_1d_mv{{.OnCluster}}
+TO {{.Database}}.events_rollup_1d AS
+SELECT
+    event_name,
+    channel,
+    toDateTime(toStartOfDay(timestamp)) AS bucket,
+    count() AS total_count,
+    uniqState(user_id) AS unique_users
+FROM {{.Database}}.events
+GROUP BY event_name, channel, bucket;
+
+-- The Kafka view was stopped above, so nothing reaches events while the
+-- backfill runs: every stored row is copied once, and events consumed from
+-- here on reach the rollups through the views above, whatever their
+-- timestamp.
+INSERT INTO {{.Database}}.events_rollup_1m
+SELECT event_name, channel, toStartOfMinute(timestamp) AS bucket, count(), uniqState(user_id)
+FROM {{.Database}}.events
+GROUP BY event_name, channel, bucket;
+
+INSERT INTO {{.Database}}.events_rollup_1h
+SELECT event_name, channel, toStartOfHour(timestamp) AS bucket, count(), uniqState(user_id)
+FROM {{.Database}}.events
+GROUP BY event_name, channel, bucket;
+
+INSERT INTO {{.Database}}.events_rollup_1d
+SELECT event_name, channel, toDateTime(toStartOfDay(timestamp)) AS bucket, count(), uniqState(user_id)
+FROM {{.Database}}.events
+GROUP BY event_name, channel, bucket;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events AS
+SELECT
+    event_hash,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    tags,
+    metadata
+FROM {{.Database}}.events_kafka;
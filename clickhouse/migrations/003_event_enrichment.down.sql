@@ -0,0 +1,43 @@
+DROP VIEW IF EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_kafka{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_kafka{{.OnCluster}} (
+    event_hash    UInt64,
+    event_name    String,
+    channel       String,
+    campaign_id   String,
+    user_id       String,
+    timestamp     UInt64,
+    tags          Array(String),
+    metadata      String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.Topic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events AS
+SELECT
+    event_hash,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    tags,
+    metadata
+FROM {{.Database}}.events_kafka;
+
+ALTER TABLE {{.Database}}.events{{.OnCluster}}
+    DROP COLUMN IF EXISTS received_at,
+    DROP COLUMN IF EXISTS client_ip,
+    DROP COLUMN IF EXISTS device_type,
+    DROP COLUMN IF EXISTS os,
+    DROP COLUMN IF EXISTS browser,
+    DROP COLUMN IF EXISTS country,
+    DROP COLUMN IF EXISTS city,
+    DROP COLUMN IF EXISTS campaign_name;
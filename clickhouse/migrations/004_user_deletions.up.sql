@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS {{.Database}}.user_deletions{{.OnCluster}} (
+    id            String,
+    user_id       String,
+    status        LowCardinality(String),
+    error         String,
+    requested_at  DateTime,
+    updated_at    DateTime64(3)
+)
+ENGINE = {{.Engine "ReplacingMergeTree(updated_at)"}}
+ORDER BY id;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS {{.Database}}.retention_policies{{.OnCluster}};
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS {{.Database}}.retention_policies{{.OnCluster}} (
+    event_name  String,
+    ttl_days    UInt32,
+    is_deleted  UInt8,
+    updated_at  DateTime64(3)
+)
+ENGINE = {{.Engine "ReplacingMergeTree(updated_at)"}}
+ORDER BY event_name;
@@ -0,0 +1,52 @@
+DROP VIEW IF EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_kafka{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_kafka{{.OnCluster}} (
+    event_hash     UInt64,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    timestamp      UInt64,
+    tags           Array(String),
+    metadata       String,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.Topic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events AS
+SELECT
+    event_hash,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    tags,
+    metadata,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{.Database}}.events_kafka;
+
+ALTER TABLE {{.Database}}.events{{.OnCluster}}
+    DROP COLUMN IF EXISTS event_id;
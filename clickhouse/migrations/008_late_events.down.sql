@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS {{.Database}}.events_late_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_late_kafka{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_late{{.OnCluster}};
@@ -0,0 +1,74 @@
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_late{{.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     LowCardinality(String),
+    channel        LowCardinality(String),
+    campaign_id    String,
+    user_id        String,
+    timestamp      DateTime,
+    event_time     DateTime64(3),
+    tags           Array(String),
+    metadata       String,
+    received_at    DateTime64(3),
+    client_ip      String,
+    device_type    LowCardinality(String),
+    os             LowCardinality(String),
+    browser        LowCardinality(String),
+    country        LowCardinality(String),
+    city           String,
+    campaign_name  String
+)
+ENGINE = {{.Engine "ReplacingMergeTree()"}}
+PARTITION BY toYYYYMM(received_at)
+ORDER BY (event_name, timestamp, channel, event_hash);
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_late_kafka{{.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.LateTopic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_late_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_late_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events_late AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{.Database}}.events_late_kafka;
@@ -0,0 +1,119 @@
+-- sample_rate is the fraction of events kept by an ingestion sampling rule,
+-- so counts can be scaled back up by 1 / sample_rate.
+ALTER TABLE {{.Database}}.events{{.OnCluster}}
+    ADD COLUMN IF NOT EXISTS sample_rate Float32 DEFAULT 1;
+
+ALTER TABLE {{.Database}}.events_late{{.OnCluster}}
+    ADD COLUMN IF NOT EXISTS sample_rate Float32 DEFAULT 1;
+
+DROP VIEW IF EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_kafka{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_kafka{{.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    sample_rate    Float64,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.Topic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    if(sample_rate > 0, sample_rate, 1) AS sample_rate,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{.Database}}.events_kafka;
+
+DROP VIEW IF EXISTS {{.Database}}.events_late_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_late_kafka{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_late_kafka{{.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    sample_rate    Float64,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.LateTopic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_late_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_late_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events_late AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    if(sample_rate > 0, sample_rate, 1) AS sample_rate,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{.Database}}.events_late_kafka;
@@ -0,0 +1,4 @@
+{{range .RoutedTopics -}}
+DROP VIEW IF EXISTS {{$.Database}}.events_{{.Name}}_kafka_mv{{$.OnCluster}};
+DROP TABLE IF EXISTS {{$.Database}}.events_{{.Name}}_kafka{{$.OnCluster}};
+{{end -}}
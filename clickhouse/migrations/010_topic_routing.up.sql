@@ -0,0 +1,64 @@
+{{/*
+Routed topics are consumed into the same events table, each with its own
+consumer group and the batching configured for it, e.g. small batches for
+low latency or large ones for throughput. With no routed topics configured
+this migration renders empty.
+*/ -}}
+{{range .RoutedTopics -}}
+CREATE TABLE IF NOT EXISTS {{$.Database}}.events_{{.Name}}_kafka{{$.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    sample_rate    Float64,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{$.Brokers}}',
+    kafka_topic_list = '{{$.Topic}}.{{.Name}}',
+    kafka_group_name = '{{$.ConsumerGroupPrefix}}_{{.Name}}_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = {{.MaxBlockSize}},
+    kafka_num_consumers = {{.NumConsumers}},
+    kafka_poll_timeout_ms = {{.PollTimeout.Milliseconds}},
+    kafka_flush_interval_ms = {{.FlushInterval.Milliseconds}};
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{$.Database}}.events_{{.Name}}_kafka_mv{{$.OnCluster}}
+TO {{$.Database}}.events AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    if(sample_rate > 0, sample_rate, 1) AS sample_rate,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{$.Database}}.events_{{.Name}}_kafka;
+
+{{end -}}
@@ -0,0 +1,203 @@
+ALTER TABLE {{.Database}}.events{{.OnCluster}}
+    ADD COLUMN IF NOT EXISTS session_id String DEFAULT '';
+
+ALTER TABLE {{.Database}}.events_late{{.OnCluster}}
+    ADD COLUMN IF NOT EXISTS session_id String DEFAULT '';
+
+DROP VIEW IF EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_kafka{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_kafka{{.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    session_id     String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    sample_rate    Float64,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.Topic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    session_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    if(sample_rate > 0, sample_rate, 1) AS sample_rate,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{.Database}}.events_kafka;
+
+DROP VIEW IF EXISTS {{.Database}}.events_late_kafka_mv{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_late_kafka{{.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_late_kafka{{.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    session_id     String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    sample_rate    Float64,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{.Brokers}}',
+    kafka_topic_list = '{{.LateTopic}}',
+    kafka_group_name = '{{.ConsumerGroupPrefix}}_late_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = 65536;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.events_late_kafka_mv{{.OnCluster}}
+TO {{.Database}}.events_late AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    session_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    if(sample_rate > 0, sample_rate, 1) AS sample_rate,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{.Database}}.events_late_kafka;
+
+{{range .RoutedTopics -}}
+DROP VIEW IF EXISTS {{$.Database}}.events_{{.Name}}_kafka_mv{{$.OnCluster}};
+DROP TABLE IF EXISTS {{$.Database}}.events_{{.Name}}_kafka{{$.OnCluster}};
+
+CREATE TABLE IF NOT EXISTS {{$.Database}}.events_{{.Name}}_kafka{{$.OnCluster}} (
+    event_hash     UInt64,
+    event_id       String,
+    event_name     String,
+    channel        String,
+    campaign_id    String,
+    user_id        String,
+    session_id     String,
+    timestamp      UInt64,
+    timestamp_ms   Int64,
+    tags           Array(String),
+    metadata       String,
+    sample_rate    Float64,
+    received_at    Int64,
+    client_ip      String,
+    device_type    String,
+    os             String,
+    browser        String,
+    country        String,
+    city           String,
+    campaign_name  String
+)
+ENGINE = Kafka()
+SETTINGS
+    kafka_broker_list = '{{$.Brokers}}',
+    kafka_topic_list = '{{$.Topic}}.{{.Name}}',
+    kafka_group_name = '{{$.ConsumerGroupPrefix}}_{{.Name}}_consumer',
+    kafka_format = 'JSONEachRow',
+    kafka_max_block_size = {{.MaxBlockSize}},
+    kafka_num_consumers = {{.NumConsumers}},
+    kafka_poll_timeout_ms = {{.PollTimeout.Milliseconds}},
+    kafka_flush_interval_ms = {{.FlushInterval.Milliseconds}};
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{$.Database}}.events_{{.Name}}_kafka_mv{{$.OnCluster}}
+TO {{$.Database}}.events AS
+SELECT
+    event_hash,
+    event_id,
+    event_name,
+    channel,
+    campaign_id,
+    user_id,
+    session_id,
+    fromUnixTimestamp(timestamp) AS timestamp,
+    if(timestamp_ms > 0, fromUnixTimestamp64Milli(timestamp_ms), toDateTime64(fromUnixTimestamp(timestamp), 3)) AS event_time,
+    tags,
+    metadata,
+    if(sample_rate > 0, sample_rate, 1) AS sample_rate,
+    fromUnixTimestamp64Milli(received_at) AS received_at,
+    client_ip,
+    device_type,
+    os,
+    browser,
+    country,
+    city,
+    campaign_name
+FROM {{$.Database}}.events_{{.Name}}_kafka;
+
+{{end -}}
+-- Sessions are written by the sessionizer. A session is rewritten with the
+-- same session_id when it is recomputed, so the latest version wins.
+CREATE TABLE IF NOT EXISTS {{.Database}}.sessions{{.OnCluster}} (
+    session_id      String,
+    user_id         String,
+    start_time      DateTime64(3),
+    end_time        DateTime64(3),
+    duration_ms     UInt64,
+    event_count     UInt64,
+    entry_event     LowCardinality(String),
+    exit_event      LowCardinality(String),
+    channel         LowCardinality(String),
+    client_session  Bool,
+    updated_at      DateTime DEFAULT now()
+)
+ENGINE = {{.Engine "ReplacingMergeTree(updated_at)"}}
+PARTITION BY toYYYYMM(start_time)
+ORDER BY (user_id, session_id);
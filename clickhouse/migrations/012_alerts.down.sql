@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS {{.Database}}.alert_history{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.alert_rules{{.OnCluster}};
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS {{.Database}}.alert_rules{{.OnCluster}} (
+    id           String,
+    event_name   String,
+    window_mins  UInt32,
+    drop_ratio   Float64,
+    spike_ratio  Float64,
+    min_baseline UInt64,
+    webhook_url  String,
+    enabled      UInt8,
+    is_deleted   UInt8,
+    updated_at   DateTime64(3)
+)
+ENGINE = {{.Engine "ReplacingMergeTree(updated_at)"}}
+ORDER BY id;
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.alert_history{{.OnCluster}} (
+    id            String,
+    rule_id       String,
+    event_name    LowCardinality(String),
+    kind          LowCardinality(String),
+    current_count UInt64,
+    baseline      Float64,
+    window_start  DateTime,
+    window_end    DateTime,
+    fired_at      DateTime64(3),
+    delivered     UInt8,
+    error         String
+)
+ENGINE = {{.Engine "MergeTree()"}}
+PARTITION BY toYYYYMM(fired_at)
+ORDER BY (fired_at, rule_id)
+TTL toDateTime(fired_at) + INTERVAL 90 DAY DELETE;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS {{.Database}}.webhook_deliveries{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.webhook_subscriptions{{.OnCluster}};
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS {{.Database}}.webhook_subscriptions{{.OnCluster}} (
+    id          String,
+    event_name  String,
+    channel     String,
+    conditions  String,
+    url         String,
+    secret      String,
+    status      LowCardinality(String),
+    is_deleted  UInt8,
+    created_at  DateTime64(3),
+    updated_at  DateTime64(3)
+)
+ENGINE = {{.Engine "ReplacingMergeTree(updated_at)"}}
+ORDER BY id;
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.webhook_deliveries{{.OnCluster}} (
+    id               String,
+    subscription_id  String,
+    event_name       LowCardinality(String),
+    event_hash       UInt64,
+    user_id          String,
+    payload          String,
+    status           LowCardinality(String),
+    attempts         UInt32,
+    next_attempt_at  DateTime64(3),
+    last_status_code UInt16,
+    last_error       String,
+    instance         LowCardinality(String),
+    created_at       DateTime64(3),
+    updated_at       DateTime64(3)
+)
+ENGINE = {{.Engine "ReplacingMergeTree(updated_at)"}}
+PARTITION BY toYYYYMM(created_at)
+ORDER BY (subscription_id, id)
+TTL toDateTime(created_at) + INTERVAL 30 DAY DELETE;
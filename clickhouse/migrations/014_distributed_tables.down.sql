@@ -0,0 +1,14 @@
+{{- if .Cluster}}
+DROP TABLE IF EXISTS {{.Database}}.webhook_deliveries_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.webhook_subscriptions_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.alert_history_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.alert_rules_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.sessions_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.retention_policies_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.user_deletions_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_rollup_1d_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_rollup_1h_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_rollup_1m_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_late_dist{{.OnCluster}};
+DROP TABLE IF EXISTS {{.Database}}.events_dist{{.OnCluster}};
+{{- end}}
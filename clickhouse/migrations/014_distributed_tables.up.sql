@@ -0,0 +1,58 @@
+{{- if .Cluster}}
+-- On a cluster, each table is replicated within its shard and a Distributed
+-- table with a _dist suffix spans the shards; the application reads and
+-- writes through it. The sharding keys send rows that share a
+-- ReplacingMergeTree key to the same shard, so FINAL still collapses them.
+-- Events consumed from Kafka stay on the shard that consumed them, which
+-- keeps duplicates together as long as they share a partition, as they do
+-- with the default event_hash partition key. Migrations that alter a table
+-- on a cluster have to alter its Distributed table as well.
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_dist{{.OnCluster}}
+AS {{.Database}}.events
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'events', event_hash);
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_late_dist{{.OnCluster}}
+AS {{.Database}}.events_late
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'events_late', event_hash);
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_rollup_1m_dist{{.OnCluster}}
+AS {{.Database}}.events_rollup_1m
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'events_rollup_1m', rand());
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_rollup_1h_dist{{.OnCluster}}
+AS {{.Database}}.events_rollup_1h
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'events_rollup_1h', rand());
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.events_rollup_1d_dist{{.OnCluster}}
+AS {{.Database}}.events_rollup_1d
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'events_rollup_1d', rand());
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.user_deletions_dist{{.OnCluster}}
+AS {{.Database}}.user_deletions
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'user_deletions', cityHash64(id));
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.retention_policies_dist{{.OnCluster}}
+AS {{.Database}}.retention_policies
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'retention_policies', cityHash64(event_name));
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.sessions_dist{{.OnCluster}}
+AS {{.Database}}.sessions
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'sessions', cityHash64(user_id));
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.alert_rules_dist{{.OnCluster}}
+AS {{.Database}}.alert_rules
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'alert_rules', cityHash64(id));
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.alert_history_dist{{.OnCluster}}
+AS {{.Database}}.alert_history
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'alert_history', cityHash64(id));
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.webhook_subscriptions_dist{{.OnCluster}}
+AS {{.Database}}.webhook_subscriptions
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'webhook_subscriptions', cityHash64(id));
+
+CREATE TABLE IF NOT EXISTS {{.Database}}.webhook_deliveries_dist{{.OnCluster}}
+AS {{.Database}}.webhook_deliveries
+ENGINE = Distributed('{{.Cluster}}', '{{.Database}}', 'webhook_deliveries', cityHash64(id));
+{{- end}}
@@ -0,0 +1,14 @@
+-- Leases let one instance at a time run work that every replica would
+-- otherwise repeat. On a cluster the table is replicated to every node, so
+-- it has no Distributed table.
+CREATE TABLE IF NOT EXISTS {{.Database}}.leases{{.OnCluster}} (
+    name        String,
+    owner       String,
+    acquired_at DateTime64(6),
+    expires_at  DateTime64(6),
+    released    UInt8,
+    version     UInt64
+)
+ENGINE = {{.SharedEngine "leases" "ReplacingMergeTree(version)"}}
+ORDER BY (name, owner)
+TTL toDateTime(expires_at) + INTERVAL 1 DAY;
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type AlertsRepository struct {
+	conn   driver.Conn
+	schema Schema
+}
+
+type AlertRuleRow struct {
+	ID          string
+	EventName   string
+	WindowMins  uint32
+	DropRatio   float64
+	SpikeRatio  float64
+	MinBaseline uint64
+	WebhookURL  string
+	Enabled     bool
+	UpdatedAt   time.Time
+}
+
+type AlertRow struct {
+	ID          string
+	RuleID      string
+	EventName   string
+	Kind        string
+	Current     uint64
+	Baseline    float64
+	WindowStart time.Time
+	WindowEnd   time.Time
+	FiredAt     time.Time
+	Delivered   bool
+	Error       string
+}
+
+// TimeWindow is a half-open time range [Start, End).
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+const alertRuleColumns = "id, event_name, window_mins, drop_ratio, spike_ratio, min_baseline, webhook_url, enabled, updated_at"
+
+func NewAlertsRepository(conn driver.Conn, schema Schema) *AlertsRepository {
+	return &AlertsRepository{conn: conn, schema: schema}
+}
+
+func (r *AlertsRepository) ListAlertRules(ctx context.Context) ([]AlertRuleRow, error) {
+	return r.queryAlertRules(ctx,
+		"SELECT "+alertRuleColumns+" FROM "+r.schema.Table("alert_rules")+" FINAL WHERE is_deleted = 0 ORDER BY event_name, id",
+	)
+}
+
+func (r *AlertsRepository) GetAlertRule(ctx context.Context, id string) (*AlertRuleRow, error) {
+	rules, err := r.queryAlertRules(ctx,
+		"SELECT "+alertRuleColumns+" FROM "+r.schema.Table("alert_rules")+" FINAL WHERE id = @id AND is_deleted = 0",
+		driver.NamedValue{Name: "id", Value: id},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return &rules[0], nil
+}
+
+func (r *AlertsRepository) queryAlertRules(ctx context.Context, query string, args ...any) ([]AlertRuleRow, error) {
+	rows, err := r.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	var results []AlertRuleRow
+	for rows.Next() {
+		var row AlertRuleRow
+		var enabled uint8
+		if err := rows.Scan(&row.ID, &row.EventName, &row.WindowMins, &row.DropRatio, &row.SpikeRatio, &row.MinBaseline, &row.WebhookURL, &enabled, &row.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		row.Enabled = enabled == 1
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+func (r *AlertsRepository) SaveAlertRule(ctx context.Context, row AlertRuleRow) error {
+	return r.writeAlertRule(ctx, row, 0)
+}
+
+func (r *AlertsRepository) DeleteAlertRule(ctx context.Context, id string) error {
+	return r.writeAlertRule(ctx, AlertRuleRow{ID: id}, 1)
+}
+
+func (r *AlertsRepository) writeAlertRule(ctx context.Context, row AlertRuleRow, deleted uint8) error {
+	err := r.conn.Exec(ctx,
+		`INSERT INTO `+r.schema.Table("alert_rules")+` (id, event_name, window_mins, drop_ratio, spike_ratio, min_baseline, webhook_url, enabled, is_deleted, updated_at)
+		VALUES (@id, @eventName, @windowMins, @dropRatio, @spikeRatio, @minBaseline, @webhookURL, @enabled, @deleted, now64(3))`,
+		driver.NamedValue{Name: "id", Value: row.ID},
+		driver.NamedValue{Name: "eventName", Value: row.EventName},
+		driver.NamedValue{Name: "windowMins", Value: row.WindowMins},
+		driver.NamedValue{Name: "dropRatio", Value: row.DropRatio},
+		driver.NamedValue{Name: "spikeRatio", Value: row.SpikeRatio},
+		driver.NamedValue{Name: "minBaseline", Value: row.MinBaseline},
+		driver.NamedValue{Name: "webhookURL", Value: row.WebhookURL},
+		driver.NamedValue{Name: "enabled", Value: boolToUInt8(row.Enabled)},
+		driver.NamedValue{Name: "deleted", Value: deleted},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write alert rule: %w", err)
+	}
+	return nil
+}
+
+// CountEventWindows returns the number of events named eventName in each
+// window, read from the per-minute rollup. Windows should be aligned to
+// whole minutes.
+func (r *AlertsRepository) CountEventWindows(ctx context.Context, eventName string, windows []TimeWindow) ([]uint64, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	args := []any{driver.NamedValue{Name: "eventName", Value: eventName}}
+	sums := make([]string, len(windows))
+	conds := make([]string, len(windows))
+	for i, w := range windows {
+		start, end := fmt.Sprintf("start%d", i), fmt.Sprintf("end%d", i)
+		cond := fmt.Sprintf("(bucket >= @%s AND bucket < @%s)", start, end)
+		sums[i] = fmt.Sprintf("sumIf(total_count, %s)", cond)
+		conds[i] = cond
+		args = append(args,
+			driver.NamedValue{Name: start, Value: w.Start},
+			driver.NamedValue{Name: end, Value: w.End},
+		)
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE event_name = @eventName AND (%s)",
+		strings.Join(sums, ", "), r.schema.Table("events_rollup_1m"), strings.Join(conds, " OR "),
+	)
+
+	counts := make([]uint64, len(windows))
+	dest := make([]any, len(windows))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	if err := r.conn.QueryRow(ctx, query, args...).Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to count events: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (r *AlertsRepository) SaveAlert(ctx context.Context, row AlertRow) error {
+	err := r.conn.Exec(ctx,
+		`INSERT INTO `+r.schema.Table("alert_history")+` (id, rule_id, event_name, kind, current_count, baseline, window_start, window_end, fired_at, delivered, error)
+		VALUES (@id, @ruleID, @eventName, @kind, @current, @baseline, @windowStart, @windowEnd, @firedAt, @delivered, @error)`,
+		driver.NamedValue{Name: "id", Value: row.ID},
+		driver.NamedValue{Name: "ruleID", Value: row.RuleID},
+		driver.NamedValue{Name: "eventName", Value: row.EventName},
+		driver.NamedValue{Name: "kind", Value: row.Kind},
+		driver.NamedValue{Name: "current", Value: row.Current},
+		driver.NamedValue{Name: "baseline", Value: row.Baseline},
+		driver.NamedValue{Name: "windowStart", Value: row.WindowStart},
+		driver.NamedValue{Name: "windowEnd", Value: row.WindowEnd},
+		driver.NamedValue{Name: "firedAt", Value: row.FiredAt},
+		driver.NamedValue{Name: "delivered", Value: boolToUInt8(row.Delivered)},
+		driver.NamedValue{Name: "error", Value: row.Error},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save alert: %w", err)
+	}
+	return nil
+}
+
+// LastAlertFiredAt returns when the rule last fired an alert of the given
+// kind, or the zero time if it never did.
+func (r *AlertsRepository) LastAlertFiredAt(ctx context.Context, ruleID, kind string) (time.Time, error) {
+	var firedAt time.Time
+	err := r.conn.QueryRow(ctx,
+		"SELECT max(fired_at) FROM "+r.schema.Table("alert_history")+" WHERE rule_id = @ruleID AND kind = @kind",
+		driver.NamedValue{Name: "ruleID", Value: ruleID},
+		driver.NamedValue{Name: "kind", Value: kind},
+	).Scan(&firedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query last alert: %w", err)
+	}
+	if firedAt.UnixMilli() <= 0 {
+		return time.Time{}, nil
+	}
+	return firedAt, nil
+}
+
+// ListAlerts returns the most recently fired alerts, newest first.
+func (r *AlertsRepository) ListAlerts(ctx context.Context, limit int) ([]AlertRow, error) {
+	rows, err := r.conn.Query(ctx,
+		`SELECT id, rule_id, event_name, kind, current_count, baseline, window_start, window_end, fired_at, delivered, error
+		FROM `+r.schema.Table("alert_history")+` ORDER BY fired_at DESC LIMIT @limit`,
+		driver.NamedValue{Name: "limit", Value: limit},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var results []AlertRow
+	for rows.Next() {
+		var row AlertRow
+		var delivered uint8
+		if err := rows.Scan(&row.ID, &row.RuleID, &row.EventName, &row.Kind, &row.Current, &row.Baseline, &row.WindowStart, &row.WindowEnd, &row.FiredAt, &delivered, &row.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		row.Delivered = delivered == 1
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+func boolToUInt8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// leaseSettleDelay gives claims by competing instances time to become
+// visible before the oldest claim is read back.
+const leaseSettleDelay = 500 * time.Millisecond
+
+// LeasesRepository hands out named leases, so that work such as a user
+// deletion or a periodic job runs on one instance at a time. ClickHouse has
+// no compare-and-set, so a lease is claimed the way the migration lock is:
+// every instance inserts a claim, waits for competing claims to land, and
+// the oldest live claim wins. A lease that is not renewed expires, so a
+// crashed holder cannot keep it for longer than its TTL.
+type LeasesRepository struct {
+	conn   driver.Conn
+	schema Schema
+}
+
+func NewLeasesRepository(conn driver.Conn, schema Schema) *LeasesRepository {
+	return &LeasesRepository{conn: conn, schema: schema}
+}
+
+// AcquireLease claims the named lease for owner until ttl from now, or
+// renews it if owner already holds it, and reports whether owner holds it.
+func (r *LeasesRepository) AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	ctx = r.consistent(ctx)
+
+	holder, err := r.leaseHolder(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if holder == owner {
+		return true, r.updateLease(ctx, name, owner, ttl, 0)
+	}
+	if holder != "" {
+		return false, nil
+	}
+
+	err = r.conn.Exec(ctx,
+		"INSERT INTO "+r.table()+" SELECT @name, @owner, now64(6), now64(6) + toIntervalMillisecond(@ttl), 0, @version",
+		driver.NamedValue{Name: "name", Value: name},
+		driver.NamedValue{Name: "owner", Value: owner},
+		driver.NamedValue{Name: "ttl", Value: ttl.Milliseconds()},
+		driver.NamedValue{Name: "version", Value: uint64(time.Now().UnixNano())},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim lease %s: %w", name, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(leaseSettleDelay):
+	}
+
+	holder, err = r.leaseHolder(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if holder == owner {
+		return true, nil
+	}
+	return false, r.updateLease(ctx, name, owner, 0, 1)
+}
+
+// ReleaseLease gives up owner's claim on the named lease.
+func (r *LeasesRepository) ReleaseLease(ctx context.Context, name, owner string) error {
+	return r.updateLease(r.consistent(ctx), name, owner, 0, 1)
+}
+
+func (r *LeasesRepository) leaseHolder(ctx context.Context, name string) (string, error) {
+	rows, err := r.conn.Query(ctx,
+		"SELECT owner FROM "+r.table()+" FINAL WHERE name = @name AND released = 0 AND expires_at > now64(6) ORDER BY acquired_at, owner LIMIT 1",
+		driver.NamedValue{Name: "name", Value: name},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to query lease %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", fmt.Errorf("rows error: %w", err)
+		}
+		return "", nil
+	}
+
+	var owner string
+	if err := rows.Scan(&owner); err != nil {
+		return "", fmt.Errorf("failed to scan row: %w", err)
+	}
+	return owner, nil
+}
+
+// updateLease rewrites owner's claim, keeping when it was acquired, to
+// expire ttl from now and be released or not.
+func (r *LeasesRepository) updateLease(ctx context.Context, name, owner string, ttl time.Duration, released uint8) error {
+	err := r.conn.Exec(ctx,
+		"INSERT INTO "+r.table()+` SELECT name, owner, acquired_at, now64(6) + toIntervalMillisecond(@ttl), @released, @version
+		FROM `+r.table()+" FINAL WHERE name = @name AND owner = @owner",
+		driver.NamedValue{Name: "ttl", Value: ttl.Milliseconds()},
+		driver.NamedValue{Name: "released", Value: released},
+		driver.NamedValue{Name: "version", Value: uint64(time.Now().UnixNano())},
+		driver.NamedValue{Name: "name", Value: name},
+		driver.NamedValue{Name: "owner", Value: owner},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// table is the leases table itself: it is replicated to every node on a
+// cluster, so there is no Distributed table in front of it.
+func (r *LeasesRepository) table() string {
+	return r.schema.Local("leases")
+}
+
+// consistent makes inserts wait for a quorum of replicas and reads see
+// every insert that reached one, when running on a cluster.
+func (r *LeasesRepository) consistent(ctx context.Context) context.Context {
+	if r.schema.Cluster == "" {
+		return ctx
+	}
+	return clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"insert_quorum":                 "auto",
+		"select_sequential_consistency": 1,
+	}))
+}
@@ -9,53 +9,137 @@ import (
 )
 
 type MetricsRepository struct {
-	conn driver.Conn
+	conn   driver.Conn
+	schema Schema
+}
+
+type MetricsFilter struct {
+	EventName string
+	StartTime *time.Time
+	EndTime   *time.Time
+	GroupBy   string
+	Interval  string
+	Timezone  string
+	Accuracy  string
+	Dedup     bool
+	Scale     bool
 }
 
 type MetricRow struct {
 	GroupKey    string
+	Bucket      time.Time
 	TotalCount  uint64
 	UniqueUsers uint64
 }
 
-func NewMetricsRepository(conn driver.Conn) *MetricsRepository {
-	return &MetricsRepository{conn: conn}
+type bucketSpec struct {
+	fn   string
+	step string
+}
+
+var bucketSpecs = map[string]bucketSpec{
+	"1m":  {fn: "toStartOfInterval(%s, INTERVAL 1 MINUTE, @tz)", step: "INTERVAL 1 MINUTE"},
+	"5m":  {fn: "toStartOfInterval(%s, INTERVAL 5 MINUTE, @tz)", step: "INTERVAL 5 MINUTE"},
+	"15m": {fn: "toStartOfInterval(%s, INTERVAL 15 MINUTE, @tz)", step: "INTERVAL 15 MINUTE"},
+	"1h":  {fn: "toStartOfInterval(%s, INTERVAL 1 HOUR, @tz)", step: "INTERVAL 1 HOUR"},
+	"1d":  {fn: "toStartOfDay(%s, @tz)", step: "INTERVAL 1 DAY"},
+	"1w":  {fn: "toDateTime(toMonday(%s, @tz), @tz)", step: "INTERVAL 1 WEEK"},
+	"1M":  {fn: "toDateTime(toStartOfMonth(%s, @tz), @tz)", step: "INTERVAL 1 MONTH"},
+}
+
+var uniqueUserFuncs = map[string]string{
+	"approx": "uniq",
+	"exact":  "uniqExact",
+	"hll":    "uniqCombined",
+}
+
+func NewMetricsRepository(conn driver.Conn, schema Schema) *MetricsRepository {
+	return &MetricsRepository{conn: conn, schema: schema}
 }
 
-func (r *MetricsRepository) GetMetrics(ctx context.Context, eventName string, startTime, endTime *time.Time, groupBy string) ([]MetricRow, error) {
+func (r *MetricsRepository) GetMetrics(ctx context.Context, filter MetricsFilter) ([]MetricRow, error) {
+	uniqFunc := "uniq"
+	if filter.Accuracy != "" {
+		var ok bool
+		uniqFunc, ok = uniqueUserFuncs[filter.Accuracy]
+		if !ok {
+			return nil, fmt.Errorf("unsupported accuracy: %s", filter.Accuracy)
+		}
+	}
+
+	source := planSource(filter, uniqFunc)
+	if filter.Dedup {
+		// Count distinct dedup keys rather than rows so duplicates are not
+		// counted before ReplacingMergeTree merges them, including retries
+		// that differ in sorting-key columns and therefore never merge.
+		source.countExpr = "uniqExact(event_hash)"
+	}
+	if filter.Scale {
+		// Sampling rules keep a fixed fraction of users, so each kept
+		// event stands for 1 / sample_rate events and the unique user
+		// count is scaled by the average inverse rate.
+		if filter.Dedup {
+			source.countExpr = fmt.Sprintf("toUInt64(round(%s * avg(1 / sample_rate)))", source.countExpr)
+		} else {
+			source.countExpr = "toUInt64(round(sum(1 / sample_rate)))"
+		}
+		source.uniqExpr = fmt.Sprintf("toUInt64(round(%s * avg(1 / sample_rate)))", source.uniqExpr)
+	}
+
 	var groupCol string
-	switch groupBy {
-	case "channel":
+	var bucket bucketSpec
+	switch {
+	case filter.Interval != "":
+		var ok bool
+		bucket, ok = bucketSpecs[filter.Interval]
+		if !ok {
+			return nil, fmt.Errorf("unsupported interval: %s", filter.Interval)
+		}
+		groupCol = fmt.Sprintf(bucket.fn, source.timeCol)
+	case filter.GroupBy == "channel":
 		groupCol = "channel"
-	case "hour":
-		groupCol = "toString(toStartOfHour(timestamp))"
-	case "day":
-		groupCol = "toString(toStartOfDay(timestamp))"
 	}
 
-	selectClause := "count() AS total_count, uniq(user_id) AS unique_users"
+	selectClause := fmt.Sprintf("%s AS total, %s AS users", source.countExpr, source.uniqExpr)
 	if groupCol != "" {
 		selectClause = fmt.Sprintf("%s AS group_key, %s", groupCol, selectClause)
 	}
 
-	query := fmt.Sprintf("SELECT %s FROM events_db.events WHERE event_name = @eventName", selectClause)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE event_name = @eventName", selectClause, r.schema.Table(source.table))
 
 	args := []any{
-		driver.NamedValue{Name: "eventName", Value: eventName},
+		driver.NamedValue{Name: "eventName", Value: filter.EventName},
 	}
 
-	if startTime != nil {
-		query += " AND timestamp >= @startTime"
-		args = append(args, driver.NamedValue{Name: "startTime", Value: *startTime})
+	if filter.StartTime != nil {
+		query += fmt.Sprintf(" AND %s >= @startTime", source.timeCol)
+		args = append(args, driver.NamedValue{Name: "startTime", Value: *filter.StartTime})
 	}
 
-	if endTime != nil {
-		query += " AND timestamp <= @endTime"
-		args = append(args, driver.NamedValue{Name: "endTime", Value: *endTime})
+	if filter.EndTime != nil {
+		query += fmt.Sprintf(" AND %s <= @endTime", source.timeCol)
+		args = append(args, driver.NamedValue{Name: "endTime", Value: *filter.EndTime})
 	}
 
 	if groupCol != "" {
-		query += fmt.Sprintf(" GROUP BY %s ORDER BY %s", groupCol, groupCol)
+		query += " GROUP BY group_key ORDER BY group_key"
+	}
+
+	if filter.Interval != "" {
+		timezone := filter.Timezone
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		args = append(args, driver.NamedValue{Name: "tz", Value: timezone})
+
+		query += " WITH FILL"
+		if filter.StartTime != nil {
+			query += " FROM " + fmt.Sprintf(bucket.fn, "@startTime")
+		}
+		if filter.EndTime != nil {
+			query += fmt.Sprintf(" TO %s + %s", fmt.Sprintf(bucket.fn, "@endTime"), bucket.step)
+		}
+		query += " STEP " + bucket.step
 	}
 
 	rows, err := r.conn.Query(ctx, query, args...)
@@ -67,14 +151,17 @@ func (r *MetricsRepository) GetMetrics(ctx context.Context, eventName string, st
 	var results []MetricRow
 	for rows.Next() {
 		var row MetricRow
-		if groupCol != "" {
-			if err := rows.Scan(&row.GroupKey, &row.TotalCount, &row.UniqueUsers); err != nil {
-				return nil, fmt.Errorf("failed to scan row: %w", err)
-			}
-		} else {
-			if err := rows.Scan(&row.TotalCount, &row.UniqueUsers); err != nil {
-				return nil, fmt.Errorf("failed to scan row: %w", err)
-			}
+		var err error
+		switch {
+		case filter.Interval != "":
+			err = rows.Scan(&row.Bucket, &row.TotalCount, &row.UniqueUsers)
+		case groupCol != "":
+			err = rows.Scan(&row.GroupKey, &row.TotalCount, &row.UniqueUsers)
+		default:
+			err = rows.Scan(&row.TotalCount, &row.UniqueUsers)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 		results = append(results, row)
 	}
@@ -85,3 +172,36 @@ func (r *MetricsRepository) GetMetrics(ctx context.Context, eventName string, st
 
 	return results, nil
 }
+
+// CountReceivedSince counts events in the filter's range that were received
+// at or after watermark, including events routed to the late-events table.
+// A non-zero count means the range has changed since the watermark.
+func (r *MetricsRepository) CountReceivedSince(ctx context.Context, filter MetricsFilter, watermark time.Time) (uint64, error) {
+	where := "event_name = @eventName AND received_at >= @watermark"
+	args := []any{
+		driver.NamedValue{Name: "eventName", Value: filter.EventName},
+		driver.NamedValue{Name: "watermark", Value: watermark},
+	}
+
+	if filter.StartTime != nil {
+		where += " AND timestamp >= @startTime"
+		args = append(args, driver.NamedValue{Name: "startTime", Value: *filter.StartTime})
+	}
+
+	if filter.EndTime != nil {
+		where += " AND timestamp <= @endTime"
+		args = append(args, driver.NamedValue{Name: "endTime", Value: *filter.EndTime})
+	}
+
+	query := fmt.Sprintf(
+		"SELECT (SELECT count() FROM %[2]s WHERE %[1]s) + (SELECT count() FROM %[3]s WHERE %[1]s)",
+		where, r.schema.Table("events"), r.schema.Table("events_late"),
+	)
+
+	var count uint64
+	if err := r.conn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count late events: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// codeBadArguments is returned by REMOVE TTL on a table without a TTL.
+const codeBadArguments = 36
+
+type RetentionRepository struct {
+	conn   driver.Conn
+	schema Schema
+}
+
+type RetentionPolicyRow struct {
+	EventName string
+	TTLDays   uint32
+	UpdatedAt time.Time
+}
+
+type PartitionRow struct {
+	Partition   string
+	Rows        uint64
+	BytesOnDisk uint64
+	Disk        string
+	MinTime     time.Time
+	MaxTime     time.Time
+	ExpiresAt   time.Time
+}
+
+// TTLRules describes the complete TTL of the events table. Per-event-name
+// rules take precedence over the default, which applies to every other event
+// name; a zero duration keeps data forever.
+type TTLRules struct {
+	PerEvent      map[string]uint32
+	DefaultDays   uint32
+	ColdVolume    string
+	ColdAfterDays uint32
+}
+
+// ttlTable is a table the retention rules apply to, with the time column
+// its TTL is based on.
+type ttlTable struct {
+	name   string
+	column string
+	rollup bool
+}
+
+// ttlTables are the events, the late events and the rollups built from the
+// events, so that an expired range reads as empty whichever table serves it.
+var ttlTables = []ttlTable{
+	{name: "events", column: "timestamp"},
+	{name: "events_late", column: "timestamp"},
+	{name: "events_rollup_1m", column: "bucket", rollup: true},
+	{name: "events_rollup_1h", column: "bucket", rollup: true},
+	{name: "events_rollup_1d", column: "bucket", rollup: true},
+}
+
+// forRollups returns the rules for the rollup tables. A bucket starts
+// before the events in it, by up to a day, so rollups keep each bucket a
+// day longer than the policy and never expire before the raw events they
+// summarize. Rollups are small and stay on the default volume.
+func (r TTLRules) forRollups() TTLRules {
+	rollup := TTLRules{PerEvent: make(map[string]uint32, len(r.PerEvent))}
+	for name, days := range r.PerEvent {
+		if days > 0 {
+			days++
+		}
+		rollup.PerEvent[name] = days
+	}
+	if r.DefaultDays > 0 {
+		rollup.DefaultDays = r.DefaultDays + 1
+	}
+	return rollup
+}
+
+func NewRetentionRepository(conn driver.Conn, schema Schema) *RetentionRepository {
+	return &RetentionRepository{conn: conn, schema: schema}
+}
+
+func (r *RetentionRepository) ListPolicies(ctx context.Context) ([]RetentionPolicyRow, error) {
+	rows, err := r.conn.Query(ctx,
+		"SELECT event_name, ttl_days, updated_at FROM "+r.schema.Table("retention_policies")+" FINAL WHERE is_deleted = 0 ORDER BY event_name",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var results []RetentionPolicyRow
+	for rows.Next() {
+		var row RetentionPolicyRow
+		if err := rows.Scan(&row.EventName, &row.TTLDays, &row.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+func (r *RetentionRepository) SavePolicy(ctx context.Context, eventName string, ttlDays uint32) error {
+	return r.writePolicy(ctx, eventName, ttlDays, 0)
+}
+
+func (r *RetentionRepository) DeletePolicy(ctx context.Context, eventName string) error {
+	return r.writePolicy(ctx, eventName, 0, 1)
+}
+
+func (r *RetentionRepository) writePolicy(ctx context.Context, eventName string, ttlDays uint32, deleted uint8) error {
+	err := r.conn.Exec(ctx,
+		"INSERT INTO "+r.schema.Table("retention_policies")+" (event_name, ttl_days, is_deleted, updated_at) VALUES (@eventName, @ttlDays, @deleted, now64(3))",
+		driver.NamedValue{Name: "eventName", Value: eventName},
+		driver.NamedValue{Name: "ttlDays", Value: ttlDays},
+		driver.NamedValue{Name: "deleted", Value: deleted},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write retention policy: %w", err)
+	}
+	return nil
+}
+
+// ApplyTTL replaces the TTL of the events, late events and rollup tables
+// with rules, skipping each table that already has it. Existing parts are
+// rewritten in the background to pick up a new expiry, so an unchanged TTL
+// is not applied again.
+func (r *RetentionRepository) ApplyTTL(ctx context.Context, rules TTLRules) error {
+	for _, table := range ttlTables {
+		tableRules := rules
+		if table.rollup {
+			tableRules = rules.forRollups()
+		}
+		if err := r.applyTableTTL(ctx, table.name, ttlExpression(tableRules, table.column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RetentionRepository) applyTableTTL(ctx context.Context, name, ttl string) error {
+	current, err := r.currentTTL(ctx, name)
+	if err != nil {
+		return err
+	}
+	if current == ttl {
+		return nil
+	}
+
+	table := r.schema.Local(name) + r.schema.OnCluster()
+	query := "ALTER TABLE " + table + " REMOVE TTL"
+	if ttl != "" {
+		query = "ALTER TABLE " + table + " MODIFY TTL " + ttl
+	}
+
+	if err := r.conn.Exec(ctx, query); err != nil {
+		// REMOVE TTL fails when the table has none, which is already the
+		// desired state.
+		var exception *clickhouse.Exception
+		if ttl == "" && errors.As(err, &exception) && exception.Code == codeBadArguments {
+			return nil
+		}
+		return fmt.Errorf("failed to apply ttl to %s: %w", name, err)
+	}
+	return nil
+}
+
+// ttlExpression renders rules on column the way ClickHouse shows a table's
+// TTL in system.tables, so that it can be compared with the one in place.
+func ttlExpression(rules TTLRules, column string) string {
+	names := make([]string, 0, len(rules.PerEvent))
+	for name := range rules.PerEvent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var clauses []string
+	literals := make([]string, len(names))
+	for i, name := range names {
+		literals[i] = quoteString(name)
+		if days := rules.PerEvent[name]; days > 0 {
+			clauses = append(clauses, fmt.Sprintf("%s + toIntervalDay(%d) WHERE event_name = %s", column, days, literals[i]))
+		}
+	}
+
+	if rules.DefaultDays > 0 {
+		clause := fmt.Sprintf("%s + toIntervalDay(%d)", column, rules.DefaultDays)
+		if len(literals) > 0 {
+			clause += fmt.Sprintf(" WHERE event_name NOT IN (%s)", strings.Join(literals, ", "))
+		}
+		clauses = append(clauses, clause)
+	}
+
+	if rules.ColdVolume != "" && rules.ColdAfterDays > 0 {
+		clauses = append(clauses, fmt.Sprintf("%s + toIntervalDay(%d) TO VOLUME %s", column, rules.ColdAfterDays, quoteString(rules.ColdVolume)))
+	}
+
+	return strings.Join(clauses, ", ")
+}
+
+// quoteString renders s as a ClickHouse string literal.
+func quoteString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// currentTTL returns the TTL of the named table as ClickHouse shows it, or
+// nothing if it has none.
+func (r *RetentionRepository) currentTTL(ctx context.Context, name string) (string, error) {
+	var engine string
+	err := r.conn.QueryRow(ctx,
+		"SELECT engine_full FROM system.tables WHERE database = @database AND name = @name",
+		driver.NamedValue{Name: "database", Value: r.schema.Database},
+		driver.NamedValue{Name: "name", Value: name},
+	).Scan(&engine)
+	if err != nil {
+		return "", fmt.Errorf("failed to query current ttl of %s: %w", name, err)
+	}
+	return ttlFromEngine(engine), nil
+}
+
+// ttlFromEngine extracts the TTL from a table's engine_full, which ends
+// with the TTL and settings clauses.
+func ttlFromEngine(engine string) string {
+	_, ttl, ok := strings.Cut(engine, " TTL ")
+	if !ok {
+		return ""
+	}
+	ttl, _, _ = strings.Cut(ttl, " SETTINGS ")
+	return ttl
+}
+
+func (r *RetentionRepository) ListPartitions(ctx context.Context) ([]PartitionRow, error) {
+	rows, err := r.conn.Query(ctx,
+		`SELECT partition, sum(rows), sum(bytes_on_disk), any(disk_name), min(min_time), max(max_time), max(delete_ttl_info_max)
+		FROM `+r.schema.System("parts")+`
+		WHERE database = @database AND table = 'events' AND active
+		GROUP BY partition
+		ORDER BY partition`,
+		driver.NamedValue{Name: "database", Value: r.schema.Database},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []PartitionRow
+	for rows.Next() {
+		var row PartitionRow
+		if err := rows.Scan(&row.Partition, &row.Rows, &row.BytesOnDisk, &row.Disk, &row.MinTime, &row.MaxTime, &row.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,70 @@
+package repository
+
+import "testing"
+
+func TestTTLExpression(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules TTLRules
+		want  string
+	}{
+		{"nothing", TTLRules{}, ""},
+		{"default only", TTLRules{DefaultDays: 30}, "timestamp + toIntervalDay(30)"},
+		{
+			"per event and default",
+			TTLRules{PerEvent: map[string]uint32{"view": 7, "purchase": 0}, DefaultDays: 30},
+			"timestamp + toIntervalDay(7) WHERE event_name = 'view', timestamp + toIntervalDay(30) WHERE event_name NOT IN ('purchase', 'view')",
+		},
+		{
+			"cold volume",
+			TTLRules{ColdVolume: "cold", ColdAfterDays: 7},
+			"timestamp + toIntervalDay(7) TO VOLUME 'cold'",
+		},
+		{
+			"quoted names",
+			TTLRules{PerEvent: map[string]uint32{`it's\`: 1}},
+			`timestamp + toIntervalDay(1) WHERE event_name = 'it\'s\\'`,
+		},
+	}
+	for _, tt := range tests {
+		if got := ttlExpression(tt.rules, "timestamp"); got != tt.want {
+			t.Errorf("%s: ttlExpression() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRollupTTLOutlastsEvents(t *testing.T) {
+	rules := TTLRules{
+		PerEvent:      map[string]uint32{"view": 7, "purchase": 0},
+		DefaultDays:   30,
+		ColdVolume:    "cold",
+		ColdAfterDays: 3,
+	}
+
+	want := "bucket + toIntervalDay(8) WHERE event_name = 'view', bucket + toIntervalDay(31) WHERE event_name NOT IN ('purchase', 'view')"
+	if got := ttlExpression(rules.forRollups(), "bucket"); got != want {
+		t.Errorf("rollup ttl = %q, want %q", got, want)
+	}
+	if got := ttlExpression(TTLRules{PerEvent: map[string]uint32{"view": 0}}.forRollups(), "bucket"); got != "" {
+		t.Errorf("rollup ttl without retention = %q, want none", got)
+	}
+}
+
+func TestTTLFromEngine(t *testing.T) {
+	tests := []struct {
+		engine string
+		want   string
+	}{
+		{"ReplacingMergeTree PARTITION BY toYYYYMMDD(timestamp) ORDER BY (event_name, timestamp) SETTINGS index_granularity = 8192", ""},
+		{
+			"ReplacingMergeTree PARTITION BY toYYYYMMDD(timestamp) ORDER BY (event_name, timestamp) TTL timestamp + toIntervalDay(30) SETTINGS index_granularity = 8192",
+			"timestamp + toIntervalDay(30)",
+		},
+		{"MergeTree ORDER BY id TTL timestamp + toIntervalDay(1)", "timestamp + toIntervalDay(1)"},
+	}
+	for _, tt := range tests {
+		if got := ttlFromEngine(tt.engine); got != tt.want {
+			t.Errorf("ttlFromEngine(%q) = %q, want %q", tt.engine, got, tt.want)
+		}
+	}
+}
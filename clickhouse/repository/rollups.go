@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"time"
+)
+
+// metricsSource describes a table the metrics query can be served from and
+// how counts and unique users are aggregated out of it.
+type metricsSource struct {
+	table     string
+	timeCol   string
+	countExpr string
+	uniqExpr  string
+}
+
+type rollup struct {
+	source metricsSource
+	step   time.Duration
+	// utcOnly rollups have bucket boundaries that only line up with the
+	// requested buckets when those are computed in UTC.
+	utcOnly bool
+}
+
+// rollups are ordered from coarsest to finest so the planner picks the one
+// that scans the fewest rows.
+var rollups = []rollup{
+	{
+		source:  rollupSource("events_rollup_1d"),
+		step:    24 * time.Hour,
+		utcOnly: true,
+	},
+	{
+		source: rollupSource("events_rollup_1h"),
+		step:   time.Hour,
+	},
+	{
+		source: rollupSource("events_rollup_1m"),
+		step:   time.Minute,
+	},
+}
+
+// intervalSteps is the length of each requested bucket interval, used to
+// decide whether a rollup is fine-grained enough to build it. Weeks and
+// months are built from whole days.
+var intervalSteps = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"1d":  24 * time.Hour,
+	"1w":  24 * time.Hour,
+	"1M":  24 * time.Hour,
+}
+
+func rollupSource(table string) metricsSource {
+	return metricsSource{
+		table:     table,
+		timeCol:   "bucket",
+		countExpr: "sum(total_count)",
+		uniqExpr:  "uniqMerge(unique_users)",
+	}
+}
+
+func rawSource(uniqFunc string) metricsSource {
+	return metricsSource{
+		table:     "events",
+		timeCol:   "timestamp",
+		countExpr: "count()",
+		uniqExpr:  uniqFunc + "(user_id)",
+	}
+}
+
+// planSource picks the coarsest rollup that can answer the filter exactly,
+// falling back to the raw events table. Rollups only keep uniq states and
+// cannot tell duplicates apart or sampled events from unsampled ones, so any
+// other accuracy, a deduplicated count or a scaled count always reads raw
+// rows.
+func planSource(filter MetricsFilter, uniqFunc string) metricsSource {
+	if uniqFunc != "uniq" || filter.Dedup || filter.Scale {
+		return rawSource(uniqFunc)
+	}
+
+	loc := time.UTC
+	if filter.Timezone != "" {
+		l, err := time.LoadLocation(filter.Timezone)
+		if err != nil {
+			return rawSource(uniqFunc)
+		}
+		loc = l
+	}
+
+	for _, r := range rollups {
+		if r.canServe(filter, loc) {
+			return r.source
+		}
+	}
+
+	return rawSource(uniqFunc)
+}
+
+func (r rollup) canServe(filter MetricsFilter, loc *time.Location) bool {
+	if filter.Interval != "" {
+		step, ok := intervalSteps[filter.Interval]
+		if !ok || step < r.step || step%r.step != 0 {
+			return false
+		}
+		if !r.alignedIn(loc, filter.StartTime, filter.EndTime) {
+			return false
+		}
+	}
+
+	if filter.StartTime != nil && filter.StartTime.Unix()%int64(r.step.Seconds()) != 0 {
+		return false
+	}
+
+	// EndTime is inclusive, so the range must stop on the last second of a
+	// bucket for the rollup to cover it exactly.
+	if filter.EndTime != nil && (filter.EndTime.Unix()+1)%int64(r.step.Seconds()) != 0 {
+		return false
+	}
+
+	return true
+}
+
+// alignedIn reports whether the rollup's UTC bucket boundaries coincide with
+// bucket boundaries in loc over the queried range.
+func (r rollup) alignedIn(loc *time.Location, start, end *time.Time) bool {
+	if loc == time.UTC {
+		return true
+	}
+	if r.utcOnly {
+		return false
+	}
+
+	points := []time.Time{time.Now()}
+	if start != nil {
+		points = append(points, *start)
+	}
+	if end != nil {
+		points = append(points, *end)
+	}
+
+	for _, t := range points {
+		_, offset := t.In(loc).Zone()
+		if offset%int(r.step.Seconds()) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func at(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func TestPlanSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter MetricsFilter
+		uniq   string
+		want   string
+	}{
+		{
+			"whole days in UTC",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-07T23:59:59Z"), Interval: "1d"},
+			"uniq", "events_rollup_1d",
+		},
+		{
+			"whole hours",
+			MetricsFilter{StartTime: at("2024-03-01T10:00:00Z"), EndTime: at("2024-03-01T15:59:59Z"), Interval: "1h"},
+			"uniq", "events_rollup_1h",
+		},
+		{
+			"five-minute buckets",
+			MetricsFilter{StartTime: at("2024-03-01T10:05:00Z"), EndTime: at("2024-03-01T10:59:59Z"), Interval: "5m"},
+			"uniq", "events_rollup_1m",
+		},
+		{
+			"unaligned start",
+			MetricsFilter{StartTime: at("2024-03-01T10:00:30Z"), EndTime: at("2024-03-01T10:59:59Z")},
+			"uniq", "events",
+		},
+		{
+			"exclusive-looking end",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-02T00:00:00Z"), Interval: "1d"},
+			"uniq", "events",
+		},
+		{
+			"days in a whole-hour offset zone",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-01T23:59:59Z"), Interval: "1h", Timezone: "Europe/Istanbul"},
+			"uniq", "events_rollup_1h",
+		},
+		{
+			"daily rollup only serves UTC",
+			MetricsFilter{StartTime: at("2024-02-29T21:00:00Z"), EndTime: at("2024-03-01T20:59:59Z"), Interval: "1d", Timezone: "Europe/Istanbul"},
+			"uniq", "events_rollup_1h",
+		},
+		{
+			"half-hour offset zone",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-01T23:59:59Z"), Interval: "1h", Timezone: "Asia/Kolkata"},
+			"uniq", "events_rollup_1m",
+		},
+		{
+			"exact unique users",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-01T23:59:59Z")},
+			"uniqExact", "events",
+		},
+		{
+			"deduplicated counts",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-01T23:59:59Z"), Dedup: true},
+			"uniq", "events",
+		},
+		{
+			"scaled counts",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-01T23:59:59Z"), Scale: true},
+			"uniq", "events",
+		},
+		{
+			"unknown timezone",
+			MetricsFilter{StartTime: at("2024-03-01T00:00:00Z"), EndTime: at("2024-03-01T23:59:59Z"), Timezone: "Nowhere/Special"},
+			"uniq", "events",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := planSource(tt.filter, tt.uniq)
+			if source.table != tt.want {
+				t.Errorf("planSource() table = %s, want %s", source.table, tt.want)
+			}
+			if tt.want == "events" && source.uniqExpr != tt.uniq+"(user_id)" {
+				t.Errorf("planSource() uniqExpr = %s", source.uniqExpr)
+			}
+		})
+	}
+}
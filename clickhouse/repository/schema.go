@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// distributedSuffix names the Distributed table that fronts each table on
+// a cluster.
+const distributedSuffix = "_dist"
+
+// Schema names the tables in the configured database. On a single server
+// every table is used directly. On a cluster each table is replicated
+// within its shard, and a Distributed table named after it with a _dist
+// suffix spans the shards: queries and inserts go through the Distributed
+// table, while mutations and TTL changes go to the tables themselves on
+// every node.
+type Schema struct {
+	Database string
+	Cluster  string
+}
+
+// Table returns the table to query and insert into.
+func (s Schema) Table(name string) string {
+	if s.Cluster != "" {
+		name += distributedSuffix
+	}
+	return s.Local(name)
+}
+
+// Local returns the table that stores the data, which is the one to alter.
+func (s Schema) Local(name string) string {
+	return s.Database + "." + name
+}
+
+// OnCluster returns the ON CLUSTER clause, with a leading space, that makes
+// DDL and mutations run on every node, or nothing on a single server.
+func (s Schema) OnCluster() string {
+	if s.Cluster == "" {
+		return ""
+	}
+	return fmt.Sprintf(" ON CLUSTER '%s'", s.Cluster)
+}
+
+// System returns the system table to query, covering one replica of every
+// shard on a cluster.
+func (s Schema) System(name string) string {
+	if s.Cluster == "" {
+		return "system." + name
+	}
+	return fmt.Sprintf("cluster('%s', system.%s)", s.Cluster, name)
+}
+
+// Engine returns a MergeTree family engine such as "MergeTree()", made
+// replicated on a cluster.
+func (s Schema) Engine(engine string) string {
+	if s.Cluster == "" {
+		return engine
+	}
+	return "Replicated" + engine
+}
+
+// SharedEngine returns a MergeTree family engine for a table that every
+// node must see in full, such as leases or the migration version. On a
+// cluster it is replicated across all nodes under one ZooKeeper path rather
+// than within each shard, so the table is queried locally rather than
+// through a Distributed table. Clauses after the engine, such as ORDER BY,
+// are kept.
+func (s Schema) SharedEngine(table, engine string) string {
+	if s.Cluster == "" {
+		return engine
+	}
+
+	name, rest, _ := strings.Cut(engine, "(")
+	args, clauses, _ := strings.Cut(rest, ")")
+	params := fmt.Sprintf("'/clickhouse/%s/%s/%s', '{shard}-{replica}'", s.Cluster, s.Database, table)
+	if args != "" {
+		params += ", " + args
+	}
+	return fmt.Sprintf("Replicated%s(%s)%s", name, params, clauses)
+}
@@ -0,0 +1,56 @@
+package repository
+
+import "testing"
+
+func TestSchemaEngines(t *testing.T) {
+	single := Schema{Database: "events_db"}
+	cluster := Schema{Database: "events_db", Cluster: "main"}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"engine on a single server", single.Engine("ReplacingMergeTree(updated_at)"), "ReplacingMergeTree(updated_at)"},
+		{"engine on a cluster", cluster.Engine("ReplacingMergeTree(updated_at)"), "ReplicatedReplacingMergeTree(updated_at)"},
+		{"shared engine on a single server", single.SharedEngine("leases", "ReplacingMergeTree(version)"), "ReplacingMergeTree(version)"},
+		{
+			"shared engine on a cluster",
+			cluster.SharedEngine("leases", "ReplacingMergeTree(version)"),
+			"ReplicatedReplacingMergeTree('/clickhouse/main/events_db/leases', '{shard}-{replica}', version)",
+		},
+		{
+			"shared engine without arguments",
+			cluster.SharedEngine("schema_migrations", "MergeTree()"),
+			"ReplicatedMergeTree('/clickhouse/main/events_db/schema_migrations', '{shard}-{replica}')",
+		},
+		{
+			"shared engine with clauses",
+			cluster.SharedEngine("schema_migrations", "MergeTree() ORDER BY sequence"),
+			"ReplicatedMergeTree('/clickhouse/main/events_db/schema_migrations', '{shard}-{replica}') ORDER BY sequence",
+		},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestSchemaTables(t *testing.T) {
+	single := Schema{Database: "events_db"}
+	cluster := Schema{Database: "events_db", Cluster: "main"}
+
+	if got := single.Table("events"); got != "events_db.events" {
+		t.Errorf("Table() = %q on a single server", got)
+	}
+	if got := cluster.Table("events"); got != "events_db.events_dist" {
+		t.Errorf("Table() = %q on a cluster", got)
+	}
+	if got := cluster.Local("events"); got != "events_db.events" {
+		t.Errorf("Local() = %q on a cluster", got)
+	}
+	if got := cluster.OnCluster(); got != " ON CLUSTER 'main'" {
+		t.Errorf("OnCluster() = %q", got)
+	}
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type SessionsRepository struct {
+	conn   driver.Conn
+	schema Schema
+}
+
+// SessionWindow bounds one sessionizer run. Events from From up to To are
+// read, and sessions whose last event falls after Since and no later than
+// ClosedBefore are written.
+type SessionWindow struct {
+	From         time.Time
+	To           time.Time
+	Since        time.Time
+	ClosedBefore time.Time
+	Gap          time.Duration
+}
+
+type SessionsFilter struct {
+	StartTime *time.Time
+	EndTime   *time.Time
+	Channel   string
+}
+
+type SessionMetricsRow struct {
+	Sessions       uint64
+	Bounces        uint64
+	AvgDurationMs  float64
+	AvgEventsCount float64
+}
+
+func NewSessionsRepository(conn driver.Conn, schema Schema) *SessionsRepository {
+	return &SessionsRepository{conn: conn, schema: schema}
+}
+
+// BuildSessions groups the window's events into sessions and stores the
+// closed ones. Events with a client session ID are grouped by it; all other
+// events of a user start a new session after an inactivity gap. Gap sessions
+// get an ID derived from the user and start time, so recomputing a session
+// replaces it rather than adding a copy. A late event can still move a
+// session's start or join two sessions, giving it a new ID, so stored
+// sessions in the window that this run did not rewrite are removed.
+func (r *SessionsRepository) BuildSessions(ctx context.Context, window SessionWindow) error {
+	updatedAt := time.Now().UTC().Truncate(time.Second)
+
+	// Inner columns are renamed so the outer aggregates can use the
+	// sessions table's column names as aliases.
+	query := `INSERT INTO ` + r.schema.Table("sessions") + `
+		(session_id, user_id, start_time, end_time, duration_ms, event_count, entry_event, exit_event, channel, client_session, updated_at)
+	SELECT
+		if(client_session_id != '', client_session_id, lower(hex(cityHash64(user_id, min(ev_time))))) AS session_id,
+		user_id,
+		min(ev_time) AS start_time,
+		max(ev_time) AS end_time,
+		toUInt64(dateDiff('millisecond', start_time, end_time)) AS duration_ms,
+		count() AS event_count,
+		argMin(ev_name, ev_time) AS entry_event,
+		argMax(ev_name, ev_time) AS exit_event,
+		argMin(ev_channel, ev_time) AS channel,
+		client_session_id != '' AS client_session,
+		@updatedAt AS updated_at
+	FROM (
+		SELECT user_id, session_id AS client_session_id, 0 AS seq,
+			event_time AS ev_time, event_name AS ev_name, channel AS ev_channel
+		FROM ` + r.schema.Table("events") + ` FINAL
+		WHERE timestamp >= @from AND timestamp < @to AND session_id != ''
+		UNION ALL
+		SELECT user_id, '' AS client_session_id,
+			sum(is_new) OVER (PARTITION BY user_id ORDER BY ev_time ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) AS seq,
+			ev_time, ev_name, ev_channel
+		FROM (
+			SELECT user_id, event_time AS ev_time, event_name AS ev_name, channel AS ev_channel,
+				dateDiff('millisecond',
+					lagInFrame(event_time, 1, toDateTime64(0, 3)) OVER (PARTITION BY user_id ORDER BY event_time ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW),
+					event_time) > @gapMs AS is_new
+			FROM ` + r.schema.Table("events") + ` FINAL
+			WHERE timestamp >= @from AND timestamp < @to AND session_id = ''
+		)
+	)
+	GROUP BY user_id, client_session_id, seq
+	HAVING end_time > @since AND end_time <= @closedBefore`
+
+	err := r.conn.Exec(ctx, query,
+		driver.NamedValue{Name: "from", Value: window.From},
+		driver.NamedValue{Name: "to", Value: window.To},
+		driver.NamedValue{Name: "since", Value: window.Since},
+		driver.NamedValue{Name: "closedBefore", Value: window.ClosedBefore},
+		driver.NamedValue{Name: "gapMs", Value: window.Gap.Milliseconds()},
+		driver.NamedValue{Name: "updatedAt", Value: updatedAt},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to build sessions: %w", err)
+	}
+
+	return r.removeStaleSessions(ctx, window, updatedAt)
+}
+
+// removeStaleSessions deletes the sessions in the window last written
+// before updatedAt. Rewritten sessions keep only their new version, so
+// what is left are sessions that no longer exist. These are rare, so the
+// mutation only runs when there is something to delete.
+func (r *SessionsRepository) removeStaleSessions(ctx context.Context, window SessionWindow, updatedAt time.Time) error {
+	const stale = "end_time > @since AND end_time <= @closedBefore AND updated_at < @updatedAt"
+	args := []any{
+		driver.NamedValue{Name: "since", Value: window.Since},
+		driver.NamedValue{Name: "closedBefore", Value: window.ClosedBefore},
+		driver.NamedValue{Name: "updatedAt", Value: updatedAt},
+	}
+
+	var count uint64
+	if err := r.conn.QueryRow(ctx, "SELECT count() FROM "+r.schema.Table("sessions")+" FINAL WHERE "+stale, args...).Scan(&count); err != nil {
+		return fmt.Errorf("failed to count stale sessions: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	if err := r.conn.Exec(ctx, "ALTER TABLE "+r.schema.Local("sessions")+r.schema.OnCluster()+" DELETE WHERE "+stale, args...); err != nil {
+		return fmt.Errorf("failed to remove stale sessions: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionsRepository) GetSessionMetrics(ctx context.Context, filter SessionsFilter) (SessionMetricsRow, error) {
+	query := `SELECT count(), countIf(event_count = 1), avgOrDefault(duration_ms), avgOrDefault(event_count)
+		FROM ` + r.schema.Table("sessions") + ` FINAL WHERE 1 = 1`
+	var args []any
+
+	if filter.StartTime != nil {
+		query += " AND start_time >= @startTime"
+		args = append(args, driver.NamedValue{Name: "startTime", Value: *filter.StartTime})
+	}
+
+	if filter.EndTime != nil {
+		query += " AND start_time <= @endTime"
+		args = append(args, driver.NamedValue{Name: "endTime", Value: *filter.EndTime})
+	}
+
+	if filter.Channel != "" {
+		query += " AND channel = @channel"
+		args = append(args, driver.NamedValue{Name: "channel", Value: filter.Channel})
+	}
+
+	var row SessionMetricsRow
+	if err := r.conn.QueryRow(ctx, query, args...).Scan(&row.Sessions, &row.Bounces, &row.AvgDurationMs, &row.AvgEventsCount); err != nil {
+		return SessionMetricsRow{}, fmt.Errorf("failed to query session metrics: %w", err)
+	}
+
+	return row, nil
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type UsersRepository struct {
+	conn   driver.Conn
+	schema Schema
+}
+
+type DeletionRow struct {
+	ID          string
+	UserID      string
+	Status      string
+	Error       string
+	RequestedAt time.Time
+	UpdatedAt   time.Time
+}
+
+type EventRow struct {
+	EventHash    uint64
+	EventID      string
+	EventName    string
+	Channel      string
+	CampaignID   string
+	UserID       string
+	SessionID    string
+	Timestamp    time.Time
+	EventTime    time.Time
+	Tags         []string
+	Metadata     string
+	ReceivedAt   time.Time
+	ClientIP     string
+	DeviceType   string
+	OS           string
+	Browser      string
+	Country      string
+	City         string
+	CampaignName string
+}
+
+func NewUsersRepository(conn driver.Conn, schema Schema) *UsersRepository {
+	return &UsersRepository{conn: conn, schema: schema}
+}
+
+func (r *UsersRepository) SaveDeletion(ctx context.Context, row DeletionRow) error {
+	err := r.conn.Exec(ctx,
+		"INSERT INTO "+r.schema.Table("user_deletions")+" (id, user_id, status, error, requested_at, updated_at) VALUES (@id, @userID, @status, @error, @requestedAt, @updatedAt)",
+		driver.NamedValue{Name: "id", Value: row.ID},
+		driver.NamedValue{Name: "userID", Value: row.UserID},
+		driver.NamedValue{Name: "status", Value: row.Status},
+		driver.NamedValue{Name: "error", Value: row.Error},
+		driver.NamedValue{Name: "requestedAt", Value: row.RequestedAt},
+		driver.NamedValue{Name: "updatedAt", Value: row.UpdatedAt},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save deletion: %w", err)
+	}
+	return nil
+}
+
+func (r *UsersRepository) GetDeletion(ctx context.Context, id string) (*DeletionRow, error) {
+	rows, err := r.conn.Query(ctx,
+		"SELECT id, user_id, status, error, requested_at, updated_at FROM "+r.schema.Table("user_deletions")+" FINAL WHERE id = @id",
+		driver.NamedValue{Name: "id", Value: id},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deletion: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error: %w", err)
+		}
+		return nil, nil
+	}
+
+	var row DeletionRow
+	if err := rows.Scan(&row.ID, &row.UserID, &row.Status, &row.Error, &row.RequestedAt, &row.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan row: %w", err)
+	}
+
+	return &row, nil
+}
+
+func (r *UsersRepository) ListDeletionsByStatus(ctx context.Context, statuses ...string) ([]DeletionRow, error) {
+	rows, err := r.conn.Query(ctx,
+		"SELECT id, user_id, status, error, requested_at, updated_at FROM "+r.schema.Table("user_deletions")+" FINAL WHERE status IN @statuses ORDER BY requested_at",
+		driver.NamedValue{Name: "statuses", Value: statuses},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []DeletionRow
+	for rows.Next() {
+		var row DeletionRow
+		if err := rows.Scan(&row.ID, &row.UserID, &row.Status, &row.Error, &row.RequestedAt, &row.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// userEventTables hold raw events, sessions or webhook payloads with user
+// IDs. Rollup tables only hold aggregate states, not user IDs, so there is
+// nothing to remove from them.
+var userEventTables = []string{"events", "events_late", "sessions", "webhook_deliveries"}
+
+// DeleteUserEvents removes every stored event under any of a user's IDs and
+// waits for the mutations to finish on all replicas.
+func (r *UsersRepository) DeleteUserEvents(ctx context.Context, userIDs []string) error {
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 2,
+	}))
+
+	for _, table := range userEventTables {
+		err := r.conn.Exec(ctx,
+			fmt.Sprintf("ALTER TABLE %s%s DELETE WHERE user_id IN @userIDs", r.schema.Local(table), r.schema.OnCluster()),
+			driver.NamedValue{Name: "userIDs", Value: userIDs},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete user events from %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// StreamUserEvents calls fn for every stored event under any of a user's
+// IDs, in time order.
+func (r *UsersRepository) StreamUserEvents(ctx context.Context, userIDs []string, fn func(EventRow) error) error {
+	columns := `event_hash, event_id, event_name, channel, campaign_id, user_id, session_id, timestamp, event_time, tags, metadata,
+		received_at, client_ip, device_type, os, browser, country, city, campaign_name`
+
+	rows, err := r.conn.Query(ctx,
+		fmt.Sprintf(`SELECT * FROM (
+			SELECT %[1]s FROM %[2]s FINAL WHERE user_id IN @userIDs
+			UNION ALL
+			SELECT %[1]s FROM %[3]s FINAL WHERE user_id IN @userIDs
+		) ORDER BY event_time`, columns, r.schema.Table("events"), r.schema.Table("events_late")),
+		driver.NamedValue{Name: "userIDs", Value: userIDs},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query user events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row EventRow
+		if err := rows.Scan(
+			&row.EventHash, &row.EventID, &row.EventName, &row.Channel, &row.CampaignID, &row.UserID, &row.SessionID, &row.Timestamp, &row.EventTime, &row.Tags, &row.Metadata,
+			&row.ReceivedAt, &row.ClientIP, &row.DeviceType, &row.OS, &row.Browser, &row.Country, &row.City, &row.CampaignName,
+		); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %w", err)
+	}
+
+	return nil
+}
+
+// EventTimeRange returns the times of the oldest and newest stored events,
+// and false if no events are stored.
+func (r *UsersRepository) EventTimeRange(ctx context.Context) (time.Time, time.Time, bool, error) {
+	var from, to time.Time
+	var count uint64
+	err := r.conn.QueryRow(ctx,
+		fmt.Sprintf(`SELECT min(event_time), max(event_time), count() FROM (
+			SELECT event_time FROM %s
+			UNION ALL
+			SELECT event_time FROM %s
+		)`, r.schema.Table("events"), r.schema.Table("events_late")),
+	).Scan(&from, &to, &count)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("failed to query event time range: %w", err)
+	}
+	return from, to, count > 0, nil
+}
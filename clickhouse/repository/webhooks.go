@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type WebhooksRepository struct {
+	conn   driver.Conn
+	schema Schema
+}
+
+type WebhookSubscriptionRow struct {
+	ID         string
+	EventName  string
+	Channel    string
+	Conditions string
+	URL        string
+	Secret     string
+	Status     string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type WebhookDeliveryRow struct {
+	ID             string
+	SubscriptionID string
+	EventName      string
+	EventHash      uint64
+	UserID         string
+	Payload        string
+	Status         string
+	Attempts       uint32
+	NextAttemptAt  time.Time
+	LastStatusCode uint16
+	LastError      string
+	Instance       string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type WebhookDeliveriesFilter struct {
+	SubscriptionID string
+	Status         string
+	Limit          int
+}
+
+const (
+	webhookSubscriptionColumns = "id, event_name, channel, conditions, url, secret, status, created_at, updated_at"
+	webhookDeliveryColumns     = "id, subscription_id, event_name, event_hash, user_id, payload, status, attempts, next_attempt_at, last_status_code, last_error, instance, created_at, updated_at"
+)
+
+func NewWebhooksRepository(conn driver.Conn, schema Schema) *WebhooksRepository {
+	return &WebhooksRepository{conn: conn, schema: schema}
+}
+
+func (r *WebhooksRepository) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscriptionRow, error) {
+	return r.queryWebhookSubscriptions(ctx,
+		"SELECT "+webhookSubscriptionColumns+" FROM "+r.schema.Table("webhook_subscriptions")+" FINAL WHERE is_deleted = 0 ORDER BY event_name, id",
+	)
+}
+
+func (r *WebhooksRepository) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscriptionRow, error) {
+	subscriptions, err := r.queryWebhookSubscriptions(ctx,
+		"SELECT "+webhookSubscriptionColumns+" FROM "+r.schema.Table("webhook_subscriptions")+" FINAL WHERE id = @id AND is_deleted = 0",
+		driver.NamedValue{Name: "id", Value: id},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, nil
+	}
+	return &subscriptions[0], nil
+}
+
+func (r *WebhooksRepository) queryWebhookSubscriptions(ctx context.Context, query string, args ...any) ([]WebhookSubscriptionRow, error) {
+	rows, err := r.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []WebhookSubscriptionRow
+	for rows.Next() {
+		var row WebhookSubscriptionRow
+		if err := rows.Scan(&row.ID, &row.EventName, &row.Channel, &row.Conditions, &row.URL, &row.Secret, &row.Status, &row.CreatedAt, &row.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+func (r *WebhooksRepository) SaveWebhookSubscription(ctx context.Context, row WebhookSubscriptionRow) error {
+	return r.writeWebhookSubscription(ctx, row, 0)
+}
+
+func (r *WebhooksRepository) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	return r.writeWebhookSubscription(ctx, WebhookSubscriptionRow{ID: id, UpdatedAt: time.Now().UTC()}, 1)
+}
+
+func (r *WebhooksRepository) writeWebhookSubscription(ctx context.Context, row WebhookSubscriptionRow, deleted uint8) error {
+	err := r.conn.Exec(ctx,
+		`INSERT INTO `+r.schema.Table("webhook_subscriptions")+` (id, event_name, channel, conditions, url, secret, status, is_deleted, created_at, updated_at)
+		VALUES (@id, @eventName, @channel, @conditions, @url, @secret, @status, @deleted, @createdAt, @updatedAt)`,
+		driver.NamedValue{Name: "id", Value: row.ID},
+		driver.NamedValue{Name: "eventName", Value: row.EventName},
+		driver.NamedValue{Name: "channel", Value: row.Channel},
+		driver.NamedValue{Name: "conditions", Value: row.Conditions},
+		driver.NamedValue{Name: "url", Value: row.URL},
+		driver.NamedValue{Name: "secret", Value: row.Secret},
+		driver.NamedValue{Name: "status", Value: row.Status},
+		driver.NamedValue{Name: "deleted", Value: deleted},
+		driver.NamedValue{Name: "createdAt", Value: row.CreatedAt},
+		driver.NamedValue{Name: "updatedAt", Value: row.UpdatedAt},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write webhook subscription: %w", err)
+	}
+	return nil
+}
+
+// SaveWebhookDelivery stores a new version of a delivery. Versions are
+// ordered by UpdatedAt, so it must grow with every save.
+func (r *WebhooksRepository) SaveWebhookDelivery(ctx context.Context, row WebhookDeliveryRow) error {
+	err := r.conn.Exec(ctx,
+		"INSERT INTO "+r.schema.Table("webhook_deliveries")+" ("+webhookDeliveryColumns+`)
+		VALUES (@id, @subscriptionID, @eventName, @eventHash, @userID, @payload, @status, @attempts, @nextAttemptAt, @lastStatusCode, @lastError, @instance, @createdAt, @updatedAt)`,
+		driver.NamedValue{Name: "id", Value: row.ID},
+		driver.NamedValue{Name: "subscriptionID", Value: row.SubscriptionID},
+		driver.NamedValue{Name: "eventName", Value: row.EventName},
+		driver.NamedValue{Name: "eventHash", Value: row.EventHash},
+		driver.NamedValue{Name: "userID", Value: row.UserID},
+		driver.NamedValue{Name: "payload", Value: row.Payload},
+		driver.NamedValue{Name: "status", Value: row.Status},
+		driver.NamedValue{Name: "attempts", Value: row.Attempts},
+		driver.NamedValue{Name: "nextAttemptAt", Value: row.NextAttemptAt},
+		driver.NamedValue{Name: "lastStatusCode", Value: row.LastStatusCode},
+		driver.NamedValue{Name: "lastError", Value: row.LastError},
+		driver.NamedValue{Name: "instance", Value: row.Instance},
+		driver.NamedValue{Name: "createdAt", Value: row.CreatedAt},
+		driver.NamedValue{Name: "updatedAt", Value: row.UpdatedAt},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// SaveWebhookDeliveries stores new deliveries in one insert.
+func (r *WebhooksRepository) SaveWebhookDeliveries(ctx context.Context, rows []WebhookDeliveryRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	batch, err := r.conn.PrepareBatch(ctx, "INSERT INTO "+r.schema.Table("webhook_deliveries")+" ("+webhookDeliveryColumns+")")
+	if err != nil {
+		return fmt.Errorf("failed to prepare webhook deliveries: %w", err)
+	}
+	defer batch.Abort()
+
+	for _, row := range rows {
+		if err := batch.Append(row.ID, row.SubscriptionID, row.EventName, row.EventHash, row.UserID, row.Payload, row.Status, row.Attempts,
+			row.NextAttemptAt, row.LastStatusCode, row.LastError, row.Instance, row.CreatedAt, row.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to append webhook delivery: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to save webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+func (r *WebhooksRepository) GetWebhookDelivery(ctx context.Context, id string) (*WebhookDeliveryRow, error) {
+	deliveries, err := r.queryWebhookDeliveries(ctx,
+		"SELECT "+webhookDeliveryColumns+" FROM "+r.schema.Table("webhook_deliveries")+" FINAL WHERE id = @id",
+		driver.NamedValue{Name: "id", Value: id},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	return &deliveries[0], nil
+}
+
+// ListWebhookDeliveries returns the most recent deliveries matching the
+// filter, newest first.
+func (r *WebhooksRepository) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveriesFilter) ([]WebhookDeliveryRow, error) {
+	query := "SELECT " + webhookDeliveryColumns + " FROM " + r.schema.Table("webhook_deliveries") + " FINAL WHERE 1 = 1"
+	var args []any
+
+	if filter.SubscriptionID != "" {
+		query += " AND subscription_id = @subscriptionID"
+		args = append(args, driver.NamedValue{Name: "subscriptionID", Value: filter.SubscriptionID})
+	}
+
+	if filter.Status != "" {
+		query += " AND status = @status"
+		args = append(args, driver.NamedValue{Name: "status", Value: filter.Status})
+	}
+
+	query += " ORDER BY created_at DESC LIMIT @limit"
+	args = append(args, driver.NamedValue{Name: "limit", Value: filter.Limit})
+
+	return r.queryWebhookDeliveries(ctx, query, args...)
+}
+
+// ListDueWebhookDeliveries returns pending deliveries whose next attempt is
+// due, for the given subscriptions only, oldest first.
+func (r *WebhooksRepository) ListDueWebhookDeliveries(ctx context.Context, subscriptionIDs []string, now time.Time, limit int) ([]WebhookDeliveryRow, error) {
+	if len(subscriptionIDs) == 0 {
+		return nil, nil
+	}
+
+	return r.queryWebhookDeliveries(ctx,
+		"SELECT "+webhookDeliveryColumns+` FROM `+r.schema.Table("webhook_deliveries")+` FINAL
+		WHERE status = 'pending' AND next_attempt_at <= @now AND has(@subscriptionIDs, subscription_id)
+		ORDER BY next_attempt_at LIMIT @limit`,
+		driver.NamedValue{Name: "now", Value: now},
+		driver.NamedValue{Name: "subscriptionIDs", Value: subscriptionIDs},
+		driver.NamedValue{Name: "limit", Value: limit},
+	)
+}
+
+func (r *WebhooksRepository) queryWebhookDeliveries(ctx context.Context, query string, args ...any) ([]WebhookDeliveryRow, error) {
+	rows, err := r.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var results []WebhookDeliveryRow
+	for rows.Next() {
+		var row WebhookDeliveryRow
+		if err := rows.Scan(&row.ID, &row.SubscriptionID, &row.EventName, &row.EventHash, &row.UserID, &row.Payload, &row.Status, &row.Attempts,
+			&row.NextAttemptAt, &row.LastStatusCode, &row.LastError, &row.Instance, &row.CreatedAt, &row.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,95 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+// testClickHouse returns the settings of the server named by
+// CLICKHOUSE_TEST_HOST, with a database of its own, and skips the test when
+// no server is given.
+func testClickHouse(t *testing.T) (config.ClickHouseConfig, config.KafkaConfig) {
+	t.Helper()
+	host := os.Getenv("CLICKHOUSE_TEST_HOST")
+	if host == "" {
+		t.Skip("CLICKHOUSE_TEST_HOST not set")
+	}
+
+	cfg := config.ClickHouseConfig{
+		Host:                 host,
+		Port:                 9000,
+		Database:             "default",
+		Username:             "default",
+		ConsumerGroupPrefix:  "clickhouse_test",
+		MigrationLockTTL:     time.Minute,
+		MigrationLockTimeout: time.Minute,
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	cfg.Database = fmt.Sprintf("events_test_%d", time.Now().UnixNano())
+	if err := client.Conn().Exec(context.Background(), "CREATE DATABASE "+cfg.Database); err != nil {
+		t.Fatalf("creating database: %v", err)
+	}
+	t.Cleanup(func() {
+		client, err := NewClient(config.ClickHouseConfig{Host: cfg.Host, Port: cfg.Port, Database: "default", Username: cfg.Username})
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		client.Conn().Exec(context.Background(), "DROP DATABASE IF EXISTS "+cfg.Database)
+	})
+
+	return cfg, config.KafkaConfig{
+		Brokers:   []string{"localhost:9092"},
+		Topic:     "events",
+		LateTopic: "events.late",
+		RoutedTopics: []config.KafkaRoutedTopic{
+			{Name: "transactional", MaxBlockSize: 1024, NumConsumers: 1, PollTimeout: 500 * time.Millisecond, FlushInterval: 500 * time.Millisecond},
+		},
+	}
+}
+
+func TestRollupsCountOldTimestampsInsertedAfterMigration(t *testing.T) {
+	cfg, kafkaCfg := testClickHouse(t)
+	ctx := context.Background()
+
+	if err := RunMigrations(ctx, cfg, kafkaCfg); err != nil {
+		t.Fatalf("RunMigrations() error = %v", err)
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+
+	// A replayed event from a year ago, stored after the rollups exist.
+	old := time.Now().UTC().AddDate(-1, 0, 0).Truncate(time.Second)
+	err = client.Conn().Exec(ctx,
+		"INSERT INTO "+cfg.Database+".events (event_hash, event_name, channel, user_id, timestamp, event_time) VALUES (1, 'replayed', 'web', 'user-1', ?, ?)",
+		old, old,
+	)
+	if err != nil {
+		t.Fatalf("inserting event: %v", err)
+	}
+
+	for _, table := range []string{"events_rollup_1m", "events_rollup_1h", "events_rollup_1d"} {
+		var count uint64
+		query := "SELECT sum(total_count) FROM " + cfg.Database + "." + table + " WHERE event_name = 'replayed'"
+		if err := client.Conn().QueryRow(ctx, query).Scan(&count); err != nil {
+			t.Fatalf("querying %s: %v", table, err)
+		}
+		if count != 1 {
+			t.Errorf("%s counts %d replayed events, want 1", table, count)
+		}
+	}
+}
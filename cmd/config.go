@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	cmd.AddCommand(newConfigPrintCmd())
+
+	return cmd
+}
+
+func newConfigPrintCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			switch format {
+			case "env":
+				for _, line := range cfg.Env() {
+					fmt.Fprintln(out, line)
+				}
+				return nil
+			case "json":
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(cfg.Redacted())
+			default:
+				return fmt.Errorf("unsupported format: %s", format)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "env", "output format: env or json")
+
+	return cmd
+}
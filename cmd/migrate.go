@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/insider/event-ingestion/clickhouse"
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+	"github.com/insider/event-ingestion/retention"
+)
+
+func newMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage ClickHouse schema migrations",
+		Long: `Manage ClickHouse schema migrations.
+
+Commands that change the schema take a lock in ClickHouse first, so when
+several instances migrate at once they run one after another.`,
+	}
+
+	cmd.AddCommand(
+		newMigrateUpCmd(),
+		newMigrateDownCmd(),
+		newMigrateGotoCmd(),
+		newMigrateStatusCmd(),
+		newMigrateForceCmd(),
+		newMigrateUnlockCmd(),
+	)
+
+	return cmd
+}
+
+func newMigrateUpCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "up [N]",
+		Short: "Apply all pending migrations, or the next N, and the retention TTL",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			steps, err := optionalSteps(args)
+			if err != nil {
+				return err
+			}
+
+			return withMigrator(func(m *clickhouse.Migrator) error {
+				if dryRun {
+					target, err := m.Target(steps)
+					if err != nil {
+						return err
+					}
+					return printPlan(cmd.OutOrStdout(), m, target)
+				}
+				if steps == 0 {
+					err = m.Up(cmd.Context())
+				} else {
+					err = m.Steps(cmd.Context(), steps)
+				}
+				if err != nil {
+					return err
+				}
+
+				// The TTL is not part of the migrations, since it is built
+				// from the retention policies, but it changes as rarely.
+				if err := applyRetention(cmd.Context()); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "warning: %v\n", err)
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations that would run without running them")
+
+	return cmd
+}
+
+func newMigrateDownCmd() *cobra.Command {
+	var all, dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "down N",
+		Short: "Roll back the last N migrations",
+		Long: `Roll back the last N migrations. Rolling back drops tables and the data
+in them. Pass --all instead of N to roll back every migration.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			steps, err := optionalSteps(args)
+			if err != nil {
+				return err
+			}
+			if all == (steps > 0) {
+				return fmt.Errorf("pass exactly one of N and --all")
+			}
+
+			return withMigrator(func(m *clickhouse.Migrator) error {
+				if dryRun {
+					var target uint
+					if !all {
+						if target, err = m.Target(-steps); err != nil {
+							return err
+						}
+					}
+					return printPlan(cmd.OutOrStdout(), m, target)
+				}
+				if all {
+					return m.Down(cmd.Context())
+				}
+				return m.Steps(cmd.Context(), -steps)
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "roll back every migration")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations that would run without running them")
+
+	return cmd
+}
+
+func newMigrateGotoCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "goto VERSION",
+		Short: "Apply or roll back migrations until the schema is at VERSION",
+		Long: `Apply or roll back migrations until the schema is at VERSION. Version 0
+rolls back every migration.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.ParseUint(args[0], 10, 0)
+			if err != nil {
+				return fmt.Errorf("invalid version: %s", args[0])
+			}
+
+			return withMigrator(func(m *clickhouse.Migrator) error {
+				if dryRun {
+					return printPlan(cmd.OutOrStdout(), m, uint(version))
+				}
+				return m.Goto(cmd.Context(), uint(version))
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the migrations that would run without running them")
+
+	return cmd
+}
+
+func newMigrateStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the schema version and the applied and pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(m *clickhouse.Migrator) error {
+				status, err := m.Status(cmd.Context())
+				if err != nil {
+					return err
+				}
+
+				out := cmd.OutOrStdout()
+				fmt.Fprintf(out, "version: %d\n", status.Version)
+				if status.Dirty {
+					fmt.Fprintf(out, "dirty: migration %d failed; fix the schema by hand, then run migrate force\n", status.Version)
+				}
+				if status.Lock != nil {
+					fmt.Fprintf(out, "locked: by %s since %s, expires %s\n",
+						status.Lock.Holder, status.Lock.AcquiredAt.Format(time.RFC3339), status.Lock.ExpiresAt.Format(time.RFC3339))
+				}
+				fmt.Fprintln(out)
+
+				w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "VERSION\tNAME\tSTATE")
+				for _, migration := range status.Migrations {
+					state := "pending"
+					if migration.Applied {
+						state = "applied"
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\n", migration.Version, migration.Name, state)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func newMigrateForceCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "force VERSION",
+		Short: "Set the schema version without running migrations",
+		Long: `Set the schema version and clear the dirty flag without running any
+migration. Use it after fixing the schema by hand following a failed
+migration.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil || version < 0 {
+				return fmt.Errorf("invalid version: %s", args[0])
+			}
+
+			return withMigrator(func(m *clickhouse.Migrator) error {
+				return m.Force(cmd.Context(), version)
+			})
+		},
+	}
+}
+
+func newMigrateUnlockCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlock",
+		Short: "Release the migration lock",
+		Long: `Release the migration lock whoever holds it. A lock whose holder died
+expires on its own after CLICKHOUSE_MIGRATION_LOCK_TTL; use this only to
+skip the wait, and only when no instance is still migrating.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(m *clickhouse.Migrator) error {
+				return m.Unlock(cmd.Context())
+			})
+		},
+	}
+}
+
+func withMigrator(fn func(m *clickhouse.Migrator) error) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	migrator, err := clickhouse.NewMigrator(cfg.ClickHouse, cfg.Kafka)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	return fn(migrator)
+}
+
+// applyRetention applies the retention policies and configured defaults to
+// the events table.
+func applyRetention(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	client, err := clickhouse.NewClient(cfg.ClickHouse)
+	if err != nil {
+		return fmt.Errorf("failed to connect to clickhouse: %w", err)
+	}
+	defer client.Close()
+
+	schema := repository.Schema{Database: cfg.ClickHouse.Database, Cluster: cfg.ClickHouse.Cluster}
+	service := retention.NewService(repository.NewRetentionRepository(client.Conn(), schema), cfg.Retention)
+	return service.Apply(ctx)
+}
+
+// printPlan prints the migrations that moving to version would run, each
+// headed by its direction, version and name and followed by its SQL.
+func printPlan(out io.Writer, m *clickhouse.Migrator, version uint) error {
+	steps, err := m.Plan(version)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Fprintln(out, "no change")
+		return nil
+	}
+
+	for _, step := range steps {
+		direction := "down"
+		if step.Up {
+			direction = "up"
+		}
+		fmt.Fprintf(out, "-- %s %d %s\n", direction, step.Version, step.Name)
+		fmt.Fprintln(out, strings.TrimSpace(step.SQL))
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// optionalSteps parses an optional positive step count, returning zero
+// when it is absent.
+func optionalSteps(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps <= 0 {
+		return 0, fmt.Errorf("invalid number of migrations: %s", args[0])
+	}
+	return steps, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/insider/event-ingestion/clickhouse"
+	"github.com/insider/event-ingestion/config"
+)
+
+func newQueryCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "query SQL",
+		Short: "Run a read-only SQL query against ClickHouse",
+		Long: `Run a SQL query against ClickHouse and print the result. The query runs
+read-only, so it cannot change data or schema. Pass "-" to read the query
+from standard input.`,
+		Example: `  server query "SELECT event_name, count() FROM events_db.events GROUP BY event_name"
+  server query --format json - < query.sql`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "table" && format != "json" {
+				return fmt.Errorf("unsupported format: %s", format)
+			}
+
+			query := args[0]
+			if query == "-" {
+				data, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return fmt.Errorf("failed to read query: %w", err)
+				}
+				query = string(data)
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			chClient, err := clickhouse.NewClient(cfg.ClickHouse)
+			if err != nil {
+				return err
+			}
+			defer chClient.Close()
+
+			columns, rows, err := chClient.QueryReadOnly(cmd.Context(), query)
+			if err != nil {
+				return err
+			}
+
+			if format == "json" {
+				return writeJSONRows(cmd.OutOrStdout(), columns, rows)
+			}
+			return writeTableRows(cmd.OutOrStdout(), columns, rows)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "table", "output format: table, or json for one object per row")
+
+	return cmd
+}
+
+func writeTableRows(out io.Writer, columns []string, rows [][]any) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = formatValue(v)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func writeJSONRows(out io.Writer, columns []string, rows [][]any) error {
+	enc := json.NewEncoder(out)
+	for _, row := range rows {
+		obj := make(map[string]any, len(columns))
+		for i, column := range columns {
+			obj[column] = row[i]
+		}
+		if err := enc.Encode(obj); err != nil {
+			return fmt.Errorf("failed to write row: %w", err)
+		}
+	}
+	return nil
+}
+
+// formatValue prints NULL for nil values of Nullable columns and the
+// pointed-to value otherwise.
+func formatValue(v any) string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return "NULL"
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return "NULL"
+	}
+	return fmt.Sprint(rv.Interface())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/insider/event-ingestion/config"
+	"github.com/insider/event-ingestion/events"
+	"github.com/insider/event-ingestion/kafka"
+)
+
+func newReplayCmd() *cobra.Command {
+	var opts events.ReplayOptions
+
+	cmd := &cobra.Command{
+		Use:   "replay [FILE]",
+		Short: "Publish events from a newline-delimited JSON file",
+		Long: `Publish events from a newline-delimited JSON file, or standard input when
+FILE is omitted or "-". Each line is an event in the body format of
+POST /events, and events go through the same checks, enrichment, rules and
+privacy policy as live traffic. Invalid lines are reported and skipped.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.BatchSize <= 0 {
+				return fmt.Errorf("batch size must be positive")
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			var in io.Reader = cmd.InOrStdin()
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to open events file: %w", err)
+				}
+				defer f.Close()
+				in = f
+			}
+
+			producer, err := kafka.NewProducer(cfg.Kafka)
+			if err != nil {
+				return fmt.Errorf("failed to create kafka producer: %w", err)
+			}
+			defer func() {
+				if err := producer.Close(); err != nil {
+					log.Printf("failed to close kafka producer: %v", err)
+				}
+			}()
+
+			service, err := newEventService(cfg, producer)
+			if err != nil {
+				return fmt.Errorf("failed to create event service: %w", err)
+			}
+			defer service.Close()
+
+			result, err := service.Replay(cmd.Context(), in, opts)
+			if opts.DryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "read %d events, %d invalid\n", result.Read, result.Invalid)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "read %d events, published %d, skipped %d invalid\n", result.Read, result.Published, result.Invalid)
+			}
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&opts.BatchSize, "batch-size", 500, "events published per batch")
+	cmd.Flags().StringVar(&opts.Tenant, "tenant", "", "tenant to attach to every event")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "validate events without publishing them")
+
+	return cmd
+}
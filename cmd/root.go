@@ -2,112 +2,43 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
-	"github.com/gin-gonic/gin"
-
-	"github.com/insider/event-ingestion/clickhouse"
-	"github.com/insider/event-ingestion/clickhouse/repository"
-	"github.com/insider/event-ingestion/config"
-	"github.com/insider/event-ingestion/events"
-	"github.com/insider/event-ingestion/kafka"
-	"github.com/insider/event-ingestion/metrics"
+	"github.com/spf13/cobra"
 )
 
 func main() {
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
-	}
-
-	producer, err := kafka.NewProducer(cfg.Kafka)
-	if err != nil {
-		log.Fatalf("failed to create kafka producer: %v", err)
-	}
-	defer func() {
-		if err := producer.Close(); err != nil {
-			log.Printf("failed to close kafka producer: %v", err)
-		}
-	}()
-
-	chClient, err := clickhouse.NewClient(cfg.ClickHouse)
+	// Interrupting a command such as replay or query cancels its context;
+	// serve handles the signals itself to shut down gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := newRootCmd().ExecuteContext(ctx)
+	stop()
 	if err != nil {
-		log.Fatalf("failed to connect to clickhouse: %v", err)
-	}
-	defer chClient.Close()
-
-	if err := clickhouse.RunMigrations(cfg.ClickHouse); err != nil {
-		log.Fatalf("failed to run migrations: %v", err)
+		os.Exit(1)
 	}
+}
 
-	metricsRepo := repository.NewMetricsRepository(chClient.Conn())
-
-	eventService := events.NewService(producer)
-	eventHandler := events.NewHandler(eventService)
-
-	metricsService := metrics.NewService(metricsRepo)
-	metricsHandler := metrics.NewHandler(metricsService)
-
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.New()
-	r.Use(gin.Recovery())
-
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-	})
-
-	r.GET("/ready", func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
-		defer cancel()
-
-		if err := chClient.Ping(ctx); err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": "clickhouse unavailable"})
-			return
-		}
-
-		if err := producer.Ping(ctx); err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": "kafka unavailable"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"status": "ready"})
-	})
-
-	eventHandler.RegisterRoutes(r)
-	metricsHandler.RegisterRoutes(r)
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "server",
+		Short: "Event ingestion server and admin tools",
+		Long: `Event ingestion server and admin tools.
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
-		Handler:      r,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
+Every command reads its configuration from the environment, the same way
+the server does.`,
+		SilenceUsage: true,
 	}
 
-	go func() {
-		log.Printf("starting server on port %d", cfg.Server.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("failed to start server: %v", err)
-		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	log.Println("shutting down server...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("server forced to shutdown: %v", err)
-	}
+	root.AddCommand(
+		newServeCmd(),
+		newMigrateCmd(),
+		newReplayCmd(),
+		newQueryCmd(),
+		newTopicCmd(),
+		newConfigCmd(),
+	)
 
-	log.Println("server exited")
+	return root
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cobra"
+
+	"github.com/insider/event-ingestion/alerts"
+	"github.com/insider/event-ingestion/auth"
+	"github.com/insider/event-ingestion/clickhouse"
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+	"github.com/insider/event-ingestion/events"
+	"github.com/insider/event-ingestion/kafka"
+	"github.com/insider/event-ingestion/metrics"
+	"github.com/insider/event-ingestion/retention"
+	"github.com/insider/event-ingestion/sessions"
+	"github.com/insider/event-ingestion/users"
+	"github.com/insider/event-ingestion/webhooks"
+)
+
+func newServeCmd() *cobra.Command {
+	var migrate bool
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the HTTP server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			serve(cfg, migrate)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&migrate, "migrate", false, "apply pending ClickHouse migrations and the retention TTL before starting")
+
+	return cmd
+}
+
+// serve runs the server until it receives SIGINT or SIGTERM. Migrations are
+// normally applied by the migrate command as a separate deploy step.
+func serve(cfg *config.Config, migrate bool) {
+	producer, err := kafka.NewProducer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("failed to create kafka producer: %v", err)
+	}
+	defer func() {
+		if err := producer.Close(); err != nil {
+			log.Printf("failed to close kafka producer: %v", err)
+		}
+	}()
+
+	if err := producer.Provision(context.Background()); err != nil {
+		log.Fatalf("failed to provision kafka topics: %v", err)
+	}
+
+	chClient, err := clickhouse.NewClient(cfg.ClickHouse)
+	if err != nil {
+		log.Fatalf("failed to connect to clickhouse: %v", err)
+	}
+	defer chClient.Close()
+
+	if migrate {
+		if err := clickhouse.RunMigrations(context.Background(), cfg.ClickHouse, cfg.Kafka); err != nil {
+			log.Fatalf("failed to run migrations: %v", err)
+		}
+	}
+
+	schema := repository.Schema{Database: cfg.ClickHouse.Database, Cluster: cfg.ClickHouse.Cluster}
+	metricsRepo := repository.NewMetricsRepository(chClient.Conn(), schema)
+	usersRepo := repository.NewUsersRepository(chClient.Conn(), schema)
+	retentionRepo := repository.NewRetentionRepository(chClient.Conn(), schema)
+	sessionsRepo := repository.NewSessionsRepository(chClient.Conn(), schema)
+	alertsRepo := repository.NewAlertsRepository(chClient.Conn(), schema)
+	webhooksRepo := repository.NewWebhooksRepository(chClient.Conn(), schema)
+	leasesRepo := repository.NewLeasesRepository(chClient.Conn(), schema)
+
+	webhooksService := webhooks.NewService(webhooksRepo, leasesRepo, webhooks.NewSender(cfg.Webhooks.Timeout), cfg.Webhooks)
+	webhooksHandler := webhooks.NewHandler(webhooksService)
+
+	var sinks []events.Sink
+	if cfg.Webhooks.Enabled {
+		webhooksService.Start()
+		sinks = append(sinks, webhooksService)
+	}
+
+	eventService, err := newEventService(cfg, producer, sinks...)
+	if err != nil {
+		log.Fatalf("failed to create event service: %v", err)
+	}
+	eventHandler := events.NewHandler(eventService)
+
+	metricsService := metrics.NewService(metricsRepo, cfg.Metrics, metrics.NewMemoryCache(cfg.Metrics.CacheMaxEntries))
+	metricsStreamer := metrics.NewStreamer(metricsService, cfg.Metrics)
+	metricsHandler := metrics.NewHandler(metricsService, metricsStreamer)
+
+	// The users service only hashes user IDs to find a user's events, so it
+	// gets a policy of its own rather than sharing the ingestion counters.
+	userIDs, err := events.NewPrivacyPolicy(cfg.Events.Privacy)
+	if err != nil {
+		log.Fatalf("failed to create privacy policy: %v", err)
+	}
+	usersService := users.NewService(usersRepo, leasesRepo, userIDs)
+	usersHandler := users.NewHandler(usersService)
+
+	if err := usersService.ResumeDeletions(context.Background()); err != nil {
+		log.Printf("failed to resume user deletions: %v", err)
+	}
+
+	retentionService := retention.NewService(retentionRepo, cfg.Retention)
+	retentionHandler := retention.NewHandler(retentionService)
+
+	if migrate {
+		if err := retentionService.Apply(context.Background()); err != nil {
+			log.Printf("failed to apply retention: %v", err)
+		}
+	}
+
+	sessionsService := sessions.NewService(sessionsRepo, leasesRepo, cfg.Sessions)
+	sessionsHandler := sessions.NewHandler(sessionsService)
+
+	if cfg.Sessions.Enabled {
+		sessionsService.Start()
+	}
+
+	alertsService := alerts.NewService(alertsRepo, leasesRepo, alerts.NewNotifier(cfg.Alerts.WebhookTimeout), cfg.Alerts)
+	alertsHandler := alerts.NewHandler(alertsService)
+
+	if cfg.Alerts.Enabled {
+		alertsService.Start()
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	})
+
+	r.GET("/ready", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+
+		if err := chClient.Ping(ctx); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": "clickhouse unavailable"})
+			return
+		}
+
+		if err := producer.Ping(ctx); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": "kafka unavailable"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	eventHandler.RegisterRoutes(r)
+	metricsHandler.RegisterRoutes(r)
+	sessionsHandler.RegisterRoutes(r)
+	admin := r.Group("/", auth.RequireAdmin(cfg.Server.AdminToken))
+	eventHandler.RegisterAdminRoutes(admin)
+	usersHandler.RegisterRoutes(admin)
+	retentionHandler.RegisterRoutes(admin)
+	alertsHandler.RegisterRoutes(admin)
+	webhooksHandler.RegisterRoutes(admin)
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+	// Streams never go idle, so they are ended as soon as shutdown starts,
+	// letting Shutdown wait for the rest of the requests in flight.
+	srv.RegisterOnShutdown(metricsStreamer.Close)
+	srv.RegisterOnShutdown(eventService.CloseTails)
+
+	go func() {
+		log.Printf("starting server on port %d", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to start server: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("server forced to shutdown: %v", err)
+	}
+
+	// Background services are stopped once no request can reach them, and
+	// before the ClickHouse client and Kafka producer they use are closed.
+	metricsStreamer.Close()
+	sessionsService.Close()
+	alertsService.Close()
+	webhooksService.Close()
+	eventService.Close()
+
+	if err := usersService.Wait(ctx); err != nil {
+		log.Printf("user deletions still running at shutdown will resume on the next start: %v", err)
+	}
+
+	log.Println("server exited")
+}
+
+// newEventService sets up the ingestion pipeline shared by serve and
+// replay.
+func newEventService(cfg *config.Config, producer *kafka.Producer, sinks ...events.Sink) (*events.Service, error) {
+	enrichers, err := events.NewEnrichers(cfg.Events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up event enrichers: %w", err)
+	}
+
+	privacy, err := events.NewPrivacyPolicy(cfg.Events.Privacy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up privacy policy: %w", err)
+	}
+
+	var rules *events.Rules
+	if cfg.Events.RulesFile != "" {
+		rules, err = events.NewRules(cfg.Events.RulesFile, cfg.Events.RulesReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load event rules: %w", err)
+		}
+	}
+
+	return events.NewService(producer, cfg.Events, enrichers, privacy, rules, sinks...)
+}
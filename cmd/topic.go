@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/insider/event-ingestion/config"
+	"github.com/insider/event-ingestion/kafka"
+)
+
+func newTopicCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "topic",
+		Short: "Manage Kafka topics",
+	}
+
+	cmd.AddCommand(newTopicCreateCmd())
+
+	return cmd
+}
+
+func newTopicCreateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "create [TOPIC...]",
+		Short: "Create Kafka topics",
+		Long: `Create the given Kafka topics, or every topic known from configuration
+(the events, late-events and route topics) when none are given. Topics get
+the configured partitions, replication factor and retention, whether or
+not KAFKA_TOPICS_PROVISION is set. Existing topics are left unchanged.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			producer, err := kafka.NewProducer(cfg.Kafka)
+			if err != nil {
+				return fmt.Errorf("failed to create kafka producer: %w", err)
+			}
+			defer func() {
+				if err := producer.Close(); err != nil {
+					log.Printf("failed to close kafka producer: %v", err)
+				}
+			}()
+
+			topics := args
+			if len(topics) == 0 {
+				topics = producer.Topics()
+			}
+
+			if err := producer.CreateTopics(cmd.Context(), topics...); err != nil {
+				return err
+			}
+			for _, topic := range topics {
+				fmt.Fprintln(cmd.OutOrStdout(), topic)
+			}
+			return nil
+		},
+	}
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -12,25 +14,183 @@ type Config struct {
 	Server     ServerConfig
 	Kafka      KafkaConfig
 	ClickHouse ClickHouseConfig
+	Events     EventsConfig
+	Metrics    MetricsConfig
+	Retention  RetentionConfig
+	Sessions   SessionsConfig
+	Alerts     AlertsConfig
+	Webhooks   WebhooksConfig
 }
 
 type ServerConfig struct {
 	Port         int           `mapstructure:"port"`
 	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	AdminToken   string        `mapstructure:"admin_token"`
 }
 
 type KafkaConfig struct {
-	Brokers []string `mapstructure:"brokers"`
-	Topic   string   `mapstructure:"topic"`
+	Brokers    []string `mapstructure:"brokers"`
+	Topic      string   `mapstructure:"topic"`
+	LateTopic  string   `mapstructure:"late_topic"`
+	RoutesFile string   `mapstructure:"routes_file"`
+
+	RoutedTopics []KafkaRoutedTopic `mapstructure:"routed_topics"`
+
+	PartitionKey string `mapstructure:"partition_key"`
+	Balancer     string `mapstructure:"balancer"`
+
+	ClientID     string        `mapstructure:"client_id"`
+	InstanceID   string        `mapstructure:"instance_id"`
+	Compression  string        `mapstructure:"compression"`
+	RequiredAcks string        `mapstructure:"required_acks"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	BatchBytes   int64         `mapstructure:"batch_bytes"`
+	BatchTimeout time.Duration `mapstructure:"batch_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	DialTimeout  time.Duration `mapstructure:"dial_timeout"`
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+
+	TLS    KafkaTLSConfig    `mapstructure:"tls"`
+	SASL   KafkaSASLConfig   `mapstructure:"sasl"`
+	Topics KafkaTopicsConfig `mapstructure:"topics"`
+}
+
+type KafkaTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+type KafkaSASLConfig struct {
+	Mechanism string `mapstructure:"mechanism"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
+}
+
+// KafkaRoutedTopic is a topic, besides the main one, that routes may send
+// events to. The topic is the main topic with Name as a suffix, and
+// ClickHouse consumes it into the events table through its own Kafka table
+// and consumer group, with the batching settings given here.
+type KafkaRoutedTopic struct {
+	Name          string        `mapstructure:"name"`
+	MaxBlockSize  int           `mapstructure:"max_block_size"`
+	NumConsumers  int           `mapstructure:"num_consumers"`
+	PollTimeout   time.Duration `mapstructure:"poll_timeout"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// KafkaTopicsConfig controls how the producer creates the topics it
+// writes to. Existing topics are left as they are.
+type KafkaTopicsConfig struct {
+	Provision         bool          `mapstructure:"provision"`
+	Partitions        int           `mapstructure:"partitions"`
+	ReplicationFactor int           `mapstructure:"replication_factor"`
+	Retention         time.Duration `mapstructure:"retention"`
 }
 
+// ClickHouseConfig controls the ClickHouse connection and the schema the
+// migrations create. Cluster, when set, names the cluster tables are created
+// on, replicated and behind Distributed tables. KafkaBrokers are the brokers
+// as the ClickHouse servers reach them, falling back to the producer's.
 type ClickHouseConfig struct {
 	Host     string `mapstructure:"host"`
 	Port     int    `mapstructure:"port"`
 	Database string `mapstructure:"database"`
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
+
+	Cluster             string   `mapstructure:"cluster"`
+	KafkaBrokers        []string `mapstructure:"kafka_brokers"`
+	ConsumerGroupPrefix string   `mapstructure:"consumer_group_prefix"`
+
+	MigrationLockTTL     time.Duration `mapstructure:"migration_lock_ttl"`
+	MigrationLockTimeout time.Duration `mapstructure:"migration_lock_timeout"`
+}
+
+type EventsConfig struct {
+	TailMaxRate         int           `mapstructure:"tail_max_rate"`
+	TailMaxSubscribers  int           `mapstructure:"tail_max_subscribers"`
+	EnrichClient        bool          `mapstructure:"enrich_client"`
+	GeoIPDatabase       string        `mapstructure:"geoip_database"`
+	CampaignMappingFile string        `mapstructure:"campaign_mapping_file"`
+	DedupFields         []string      `mapstructure:"dedup_fields"`
+	FutureSkewTolerance time.Duration `mapstructure:"future_skew_tolerance"`
+	MaxAge              time.Duration `mapstructure:"max_age"`
+	LatePolicy          string        `mapstructure:"late_policy"`
+	LateThreshold       time.Duration `mapstructure:"late_threshold"`
+	RulesFile           string        `mapstructure:"rules_file"`
+	RulesReloadInterval time.Duration `mapstructure:"rules_reload_interval"`
+	Privacy             PrivacyConfig `mapstructure:"privacy"`
+}
+
+type PrivacyConfig struct {
+	DropKeys           []string      `mapstructure:"drop_keys"`
+	MaskKeys           []string      `mapstructure:"mask_keys"`
+	HashKeys           []string      `mapstructure:"hash_keys"`
+	DetectPII          bool          `mapstructure:"detect_pii"`
+	DetectedAction     string        `mapstructure:"detected_action"`
+	HashSecret         string        `mapstructure:"hash_secret"`
+	HashUserID         bool          `mapstructure:"hash_user_id"`
+	UserIDSaltRotation time.Duration `mapstructure:"user_id_salt_rotation"`
+}
+
+type MetricsConfig struct {
+	MaxExactRange      time.Duration `mapstructure:"max_exact_range"`
+	CacheLiveTTL       time.Duration `mapstructure:"cache_live_ttl"`
+	CacheHistoricalTTL time.Duration `mapstructure:"cache_historical_ttl"`
+	CacheSettleWindow  time.Duration `mapstructure:"cache_settle_window"`
+	CacheMaxEntries    int           `mapstructure:"cache_max_entries"`
+	StreamInterval     time.Duration `mapstructure:"stream_interval"`
+	StreamMaxFeeds     int           `mapstructure:"stream_max_feeds"`
+}
+
+// SessionsConfig controls the sessionizer. Sessions end after
+// InactivityGap without events, and each run rebuilds the sessions that
+// ended within Lookback, reading a further Lookback of earlier events so
+// that they are rebuilt from their first event.
+type SessionsConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	InactivityGap time.Duration `mapstructure:"inactivity_gap"`
+	Interval      time.Duration `mapstructure:"interval"`
+	Lookback      time.Duration `mapstructure:"lookback"`
+}
+
+// AlertsConfig controls volume anomaly detection. Each rule's latest
+// window, ending EvaluationDelay ago to let ingestion catch up, is compared
+// to the same window in each of the previous BaselineWeeks weeks.
+type AlertsConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Interval        time.Duration `mapstructure:"interval"`
+	EvaluationDelay time.Duration `mapstructure:"evaluation_delay"`
+	BaselineWeeks   int           `mapstructure:"baseline_weeks"`
+	Cooldown        time.Duration `mapstructure:"cooldown"`
+	WebhookURL      string        `mapstructure:"webhook_url"`
+	WebhookTimeout  time.Duration `mapstructure:"webhook_timeout"`
+}
+
+// WebhooksConfig controls outbound event webhooks. A failed delivery is
+// retried after RetryBackoff, doubling up to MaxBackoff, until MaxAttempts
+// have failed.
+type WebhooksConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Workers         int           `mapstructure:"workers"`
+	QueueSize       int           `mapstructure:"queue_size"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	MaxBackoff      time.Duration `mapstructure:"max_backoff"`
+	RetryInterval   time.Duration `mapstructure:"retry_interval"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+type RetentionConfig struct {
+	DefaultDays   uint32 `mapstructure:"default_days"`
+	ColdVolume    string `mapstructure:"cold_volume"`
+	ColdAfterDays uint32 `mapstructure:"cold_after_days"`
 }
 
 func Load() (*Config, error) {
@@ -39,23 +199,176 @@ func Load() (*Config, error) {
 	v.SetDefault("server.port", 8080)
 	v.SetDefault("server.read_timeout", "5s")
 	v.SetDefault("server.write_timeout", "10s")
+	v.SetDefault("server.admin_token", "")
 
 	v.SetDefault("kafka.brokers", []string{"localhost:19092"})
 	v.SetDefault("kafka.topic", "events")
+	v.SetDefault("kafka.late_topic", "events.late")
+	v.SetDefault("kafka.routes_file", "")
+	v.SetDefault("kafka.routed_topics", []map[string]any{
+		{"name": "transactional", "max_block_size": 1024, "num_consumers": 1, "poll_timeout": "500ms", "flush_interval": "500ms"},
+		{"name": "behavioral", "max_block_size": 262144, "num_consumers": 1, "poll_timeout": "500ms", "flush_interval": "15s"},
+	})
+	v.SetDefault("kafka.partition_key", "event_hash")
+	v.SetDefault("kafka.balancer", "least_bytes")
+	v.SetDefault("kafka.client_id", "event-ingestion")
+	v.SetDefault("kafka.instance_id", "")
+	v.SetDefault("kafka.compression", "lz4")
+	v.SetDefault("kafka.required_acks", "all")
+	v.SetDefault("kafka.batch_size", 100)
+	v.SetDefault("kafka.batch_bytes", 1048576)
+	v.SetDefault("kafka.batch_timeout", "1s")
+	v.SetDefault("kafka.write_timeout", "10s")
+	v.SetDefault("kafka.dial_timeout", "5s")
+	v.SetDefault("kafka.max_attempts", 10)
+	v.SetDefault("kafka.tls.enabled", false)
+	v.SetDefault("kafka.tls.ca_file", "")
+	v.SetDefault("kafka.tls.cert_file", "")
+	v.SetDefault("kafka.tls.key_file", "")
+	v.SetDefault("kafka.tls.server_name", "")
+	v.SetDefault("kafka.tls.insecure_skip_verify", false)
+	v.SetDefault("kafka.sasl.mechanism", "")
+	v.SetDefault("kafka.sasl.username", "")
+	v.SetDefault("kafka.sasl.password", "")
+	v.SetDefault("kafka.topics.provision", true)
+	v.SetDefault("kafka.topics.partitions", 6)
+	v.SetDefault("kafka.topics.replication_factor", 1)
+	v.SetDefault("kafka.topics.retention", "168h")
 
 	v.SetDefault("clickhouse.host", "localhost")
 	v.SetDefault("clickhouse.port", 9000)
 	v.SetDefault("clickhouse.database", "events_db")
 	v.SetDefault("clickhouse.username", "default")
 	v.SetDefault("clickhouse.password", "")
+	v.SetDefault("clickhouse.cluster", "")
+	v.SetDefault("clickhouse.kafka_brokers", []string{})
+	v.SetDefault("clickhouse.consumer_group_prefix", "clickhouse_events")
+	v.SetDefault("clickhouse.migration_lock_ttl", "1m")
+	v.SetDefault("clickhouse.migration_lock_timeout", "10m")
+
+	v.SetDefault("events.tail_max_rate", 100)
+	v.SetDefault("events.tail_max_subscribers", 10)
+	v.SetDefault("events.enrich_client", true)
+	v.SetDefault("events.geoip_database", "")
+	v.SetDefault("events.campaign_mapping_file", "")
+	v.SetDefault("events.future_skew_tolerance", "5m")
+	v.SetDefault("events.max_age", "0s")
+	v.SetDefault("events.late_policy", "accept")
+	v.SetDefault("events.late_threshold", "24h")
+	v.SetDefault("events.rules_file", "")
+	v.SetDefault("events.rules_reload_interval", "10s")
+	v.SetDefault("events.dedup_fields", []string{"event_name", "user_id", "timestamp"})
+	v.SetDefault("events.privacy.drop_keys", []string{})
+	v.SetDefault("events.privacy.mask_keys", []string{})
+	v.SetDefault("events.privacy.hash_keys", []string{})
+	v.SetDefault("events.privacy.detect_pii", false)
+	v.SetDefault("events.privacy.detected_action", "mask")
+	v.SetDefault("events.privacy.hash_secret", "")
+	v.SetDefault("events.privacy.hash_user_id", false)
+	v.SetDefault("events.privacy.user_id_salt_rotation", "720h")
+
+	v.SetDefault("metrics.max_exact_range", "744h")
+	v.SetDefault("metrics.cache_live_ttl", "5s")
+	v.SetDefault("metrics.cache_historical_ttl", "1h")
+	v.SetDefault("metrics.cache_settle_window", "10m")
+	v.SetDefault("metrics.cache_max_entries", 10000)
+	v.SetDefault("metrics.stream_interval", "5s")
+	v.SetDefault("metrics.stream_max_feeds", 100)
+
+	v.SetDefault("retention.default_days", 0)
+	v.SetDefault("retention.cold_volume", "")
+	v.SetDefault("retention.cold_after_days", 0)
+
+	v.SetDefault("sessions.enabled", true)
+	v.SetDefault("sessions.inactivity_gap", "30m")
+	v.SetDefault("sessions.interval", "1m")
+	v.SetDefault("sessions.lookback", "24h")
+
+	v.SetDefault("alerts.enabled", true)
+	v.SetDefault("alerts.interval", "5m")
+	v.SetDefault("alerts.evaluation_delay", "2m")
+	v.SetDefault("alerts.baseline_weeks", 4)
+	v.SetDefault("alerts.cooldown", "1h")
+	v.SetDefault("alerts.webhook_url", "")
+	v.SetDefault("alerts.webhook_timeout", "5s")
+
+	v.SetDefault("webhooks.enabled", true)
+	v.SetDefault("webhooks.workers", 4)
+	v.SetDefault("webhooks.queue_size", 10000)
+	v.SetDefault("webhooks.timeout", "10s")
+	v.SetDefault("webhooks.max_attempts", 8)
+	v.SetDefault("webhooks.retry_backoff", "30s")
+	v.SetDefault("webhooks.max_backoff", "1h")
+	v.SetDefault("webhooks.retry_interval", "10s")
+	v.SetDefault("webhooks.refresh_interval", "30s")
 
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
+	// Routed topics are a list of settings, which the environment can only
+	// hold as JSON.
+	if raw, ok := v.Get("kafka.routed_topics").(string); ok {
+		var topics []map[string]any
+		if err := json.Unmarshal([]byte(raw), &topics); err != nil {
+			return nil, fmt.Errorf("failed to parse kafka.routed_topics: %w", err)
+		}
+		v.Set("kafka.routed_topics", topics)
+	}
+
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
+
+// validate rejects intervals that background loops tick at, which must be
+// positive. Loops that are disabled are not checked.
+func (c *Config) validate() error {
+	intervals := []struct {
+		key     string
+		value   time.Duration
+		enabled bool
+	}{
+		{"clickhouse.migration_lock_ttl", c.ClickHouse.MigrationLockTTL, true},
+		{"metrics.stream_interval", c.Metrics.StreamInterval, true},
+		{"sessions.interval", c.Sessions.Interval, c.Sessions.Enabled},
+		{"alerts.interval", c.Alerts.Interval, c.Alerts.Enabled},
+		{"webhooks.retry_interval", c.Webhooks.RetryInterval, c.Webhooks.Enabled},
+		{"webhooks.refresh_interval", c.Webhooks.RefreshInterval, c.Webhooks.Enabled},
+	}
+	for _, interval := range intervals {
+		if interval.enabled && interval.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", interval.key, interval.value)
+		}
+	}
+	return c.Kafka.validateRoutedTopics()
+}
+
+// routedTopicName is what a routed topic name may contain, since it becomes
+// part of ClickHouse table and consumer group names.
+var routedTopicName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// validateRoutedTopics rejects routed topics that the migrations could not
+// create Kafka tables for.
+func (c *KafkaConfig) validateRoutedTopics() error {
+	seen := make(map[string]bool, len(c.RoutedTopics))
+	for i, topic := range c.RoutedTopics {
+		if !routedTopicName.MatchString(topic.Name) {
+			return fmt.Errorf("kafka.routed_topics %d: name %q must only contain lowercase letters, digits and underscores", i, topic.Name)
+		}
+		if seen[topic.Name] {
+			return fmt.Errorf("kafka.routed_topics %d: name %s is used twice", i, topic.Name)
+		}
+		seen[topic.Name] = true
+
+		if topic.MaxBlockSize <= 0 || topic.NumConsumers <= 0 || topic.PollTimeout <= 0 || topic.FlushInterval <= 0 {
+			return fmt.Errorf("kafka.routed_topics %s: max_block_size, num_consumers, poll_timeout and flush_interval must be positive", topic.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+}
+
+func TestLoadRejectsNonPositiveIntervals(t *testing.T) {
+	tests := []struct {
+		env   map[string]string
+		error string
+	}{
+		{map[string]string{"METRICS_STREAM_INTERVAL": "0s"}, "metrics.stream_interval"},
+		{map[string]string{"SESSIONS_INTERVAL": "-1m"}, "sessions.interval"},
+		{map[string]string{"ALERTS_INTERVAL": "0s"}, "alerts.interval"},
+		{map[string]string{"WEBHOOKS_ENABLED": "true", "WEBHOOKS_RETRY_INTERVAL": "0s"}, "webhooks.retry_interval"},
+		{map[string]string{"CLICKHOUSE_MIGRATION_LOCK_TTL": "0s"}, "clickhouse.migration_lock_ttl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.error, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := Load()
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("Load() error = %v, want one naming %s", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestLoadIgnoresDisabledLoops(t *testing.T) {
+	t.Setenv("SESSIONS_ENABLED", "false")
+	t.Setenv("SESSIONS_INTERVAL", "0s")
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+}
+
+func TestLoadRoutedTopics(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := []KafkaRoutedTopic{
+		{Name: "transactional", MaxBlockSize: 1024, NumConsumers: 1, PollTimeout: 500 * time.Millisecond, FlushInterval: 500 * time.Millisecond},
+		{Name: "behavioral", MaxBlockSize: 262144, NumConsumers: 1, PollTimeout: 500 * time.Millisecond, FlushInterval: 15 * time.Second},
+	}
+	if !slices.Equal(cfg.Kafka.RoutedTopics, want) {
+		t.Errorf("default routed topics = %+v, want %+v", cfg.Kafka.RoutedTopics, want)
+	}
+
+	t.Setenv("KAFKA_ROUTED_TOPICS", `[{"name": "orders", "max_block_size": 512, "num_consumers": 2, "poll_timeout": "100ms", "flush_interval": "1s"}]`)
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want = []KafkaRoutedTopic{
+		{Name: "orders", MaxBlockSize: 512, NumConsumers: 2, PollTimeout: 100 * time.Millisecond, FlushInterval: time.Second},
+	}
+	if !slices.Equal(cfg.Kafka.RoutedTopics, want) {
+		t.Errorf("routed topics = %+v, want %+v", cfg.Kafka.RoutedTopics, want)
+	}
+}
+
+func TestLoadRejectsInvalidRoutedTopics(t *testing.T) {
+	for _, value := range []string{
+		`not json`,
+		`[{"name": "Orders.EU", "max_block_size": 512, "num_consumers": 1, "poll_timeout": "100ms", "flush_interval": "1s"}]`,
+		`[{"name": "orders", "num_consumers": 1, "poll_timeout": "100ms", "flush_interval": "1s"}]`,
+		`[{"name": "orders", "max_block_size": 512, "num_consumers": 1, "poll_timeout": "100ms", "flush_interval": "1s"},
+		  {"name": "orders", "max_block_size": 512, "num_consumers": 1, "poll_timeout": "100ms", "flush_interval": "1s"}]`,
+	} {
+		t.Setenv("KAFKA_ROUTED_TOPICS", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() accepted routed topics %s", value)
+		}
+	}
+}
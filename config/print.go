@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// secretKeys are the keys whose values are never printed.
+var secretKeys = map[string]struct{}{
+	"admin_token": {},
+	"password":    {},
+	"hash_secret": {},
+}
+
+// Redacted returns the settings as nested maps keyed by config key, with
+// durations as strings and secrets that are set replaced by REDACTED.
+func (c *Config) Redacted() map[string]any {
+	return redactStruct(reflect.ValueOf(*c))
+}
+
+// Env returns the settings as sorted KEY=value lines, named after the
+// environment variables that set them, with secrets redacted. List values
+// are separated by spaces and lists of settings are JSON, which is how they
+// are read back.
+func (c *Config) Env() []string {
+	var lines []string
+	flattenEnv("", c.Redacted(), &lines)
+	slices.Sort(lines)
+	return lines
+}
+
+func redactStruct(v reflect.Value) map[string]any {
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := range t.NumField() {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		out[key] = redactValue(key, v.Field(i))
+	}
+	return out
+}
+
+func redactValue(key string, v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	if v.Kind() == reflect.Struct {
+		return redactStruct(v)
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct {
+		out := make([]map[string]any, v.Len())
+		for i := range v.Len() {
+			out[i] = redactStruct(v.Index(i))
+		}
+		return out
+	}
+	if _, ok := secretKeys[key]; ok && !v.IsZero() {
+		return redacted
+	}
+	return v.Interface()
+}
+
+func flattenEnv(prefix string, m map[string]any, lines *[]string) {
+	for key, value := range m {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			flattenEnv(name, value, lines)
+		case []string:
+			*lines = append(*lines, name+"="+strings.Join(value, " "))
+		case []map[string]any:
+			data, _ := json.Marshal(value)
+			*lines = append(*lines, name+"="+string(data))
+		default:
+			*lines = append(*lines, fmt.Sprintf("%s=%v", name, value))
+		}
+	}
+}
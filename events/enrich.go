@@ -0,0 +1,180 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+// Enricher adds server-side information to an event before it is published.
+// Enrichment is best effort: an enricher that cannot resolve anything leaves
+// the event unchanged rather than failing ingestion.
+type Enricher interface {
+	Enrich(ctx context.Context, event *Event)
+}
+
+// RequestInfo carries details of the HTTP request an event arrived with.
+type RequestInfo struct {
+	ClientIP    string
+	UserAgent   string
+	Tenant      string
+	RequestID   string
+	TraceParent string
+	ReceivedAt  time.Time
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+func requestInfoFrom(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// NewEnrichers builds the enrichment chain enabled in cfg, in the order they
+// run.
+func NewEnrichers(cfg config.EventsConfig) ([]Enricher, error) {
+	enrichers := []Enricher{ReceiveTimeEnricher{}, RequestEnricher{}}
+
+	if cfg.EnrichClient {
+		enrichers = append(enrichers, ClientEnricher{})
+	}
+
+	if cfg.GeoIPDatabase != "" {
+		geoIP, err := NewGeoIPEnricher(cfg.GeoIPDatabase)
+		if err != nil {
+			return nil, err
+		}
+		enrichers = append(enrichers, geoIP)
+	}
+
+	if cfg.CampaignMappingFile != "" {
+		campaigns, err := NewCampaignEnricher(cfg.CampaignMappingFile)
+		if err != nil {
+			return nil, err
+		}
+		enrichers = append(enrichers, campaigns)
+	}
+
+	return enrichers, nil
+}
+
+// ReceiveTimeEnricher stamps the time the server received the event.
+type ReceiveTimeEnricher struct{}
+
+func (ReceiveTimeEnricher) Enrich(ctx context.Context, event *Event) {
+	if info, ok := requestInfoFrom(ctx); ok && !info.ReceivedAt.IsZero() {
+		event.ReceivedAt = info.ReceivedAt
+		return
+	}
+	event.ReceivedAt = time.Now()
+}
+
+// RequestEnricher records the tenant the request was sent for, which topic
+// routes can match on, and the request and trace IDs that are passed on in
+// message headers.
+type RequestEnricher struct{}
+
+func (RequestEnricher) Enrich(ctx context.Context, event *Event) {
+	if info, ok := requestInfoFrom(ctx); ok {
+		event.Tenant = info.Tenant
+		event.RequestID = info.RequestID
+		event.TraceParent = info.TraceParent
+	}
+}
+
+// ClientEnricher records the client IP and the device, OS and browser parsed
+// from the User-Agent header.
+type ClientEnricher struct{}
+
+func (ClientEnricher) Enrich(ctx context.Context, event *Event) {
+	info, ok := requestInfoFrom(ctx)
+	if !ok {
+		return
+	}
+
+	event.ClientIP = info.ClientIP
+	ua := parseUserAgent(info.UserAgent)
+	event.DeviceType = ua.device
+	event.OS = ua.os
+	event.Browser = ua.browser
+}
+
+// GeoIPEnricher resolves the client IP to a country and city using a local
+// MaxMind-format (mmdb) database. It must run after ClientEnricher.
+type GeoIPEnricher struct {
+	reader *maxminddb.Reader
+}
+
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+func NewGeoIPEnricher(path string) (*GeoIPEnricher, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geoip database: %w", err)
+	}
+	return &GeoIPEnricher{reader: reader}, nil
+}
+
+func (e *GeoIPEnricher) Enrich(_ context.Context, event *Event) {
+	addr, err := netip.ParseAddr(event.ClientIP)
+	if err != nil {
+		return
+	}
+
+	var record geoIPRecord
+	if err := e.reader.Lookup(addr).Decode(&record); err != nil {
+		return
+	}
+
+	event.Country = record.Country.ISOCode
+	event.City = record.City.Names["en"]
+}
+
+func (e *GeoIPEnricher) Close() error {
+	return e.reader.Close()
+}
+
+// CampaignEnricher resolves campaign IDs to names from a static JSON mapping
+// of campaign ID to name.
+type CampaignEnricher struct {
+	names map[string]string
+}
+
+func NewCampaignEnricher(path string) (*CampaignEnricher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read campaign mapping: %w", err)
+	}
+
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse campaign mapping: %w", err)
+	}
+
+	return &CampaignEnricher{names: names}, nil
+}
+
+func (e *CampaignEnricher) Enrich(_ context.Context, event *Event) {
+	if event.CampaignID == "" {
+		return
+	}
+	event.CampaignName = e.names[event.CampaignID]
+}
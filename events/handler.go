@@ -1,12 +1,16 @@
 package events
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type Handler struct {
@@ -20,34 +24,61 @@ func NewHandler(service *Service) *Handler {
 }
 
 type EventRequest struct {
+	EventID    string         `json:"event_id" binding:"omitempty,uuid|ulid"`
 	EventName  string         `json:"event_name" binding:"required"`
 	Channel    string         `json:"channel" binding:"required,oneof=web mobile api email push"`
 	CampaignID string         `json:"campaign_id" binding:"omitempty"`
 	UserID     string         `json:"user_id" binding:"required"`
-	Timestamp  int64          `json:"timestamp" binding:"required"`
+	SessionID  string         `json:"session_id" binding:"omitempty,max=128"`
+	Timestamp  Timestamp      `json:"timestamp" binding:"required"`
 	Tags       []string       `json:"tags" binding:"omitempty"`
 	Metadata   map[string]any `json:"metadata" binding:"omitempty"`
 }
 
-func validateTimestamp(ts int64) error {
-	if ts <= 0 || ts > time.Now().Unix() {
-		return fmt.Errorf("invalid timestamp: must be a positive Unix timestamp in seconds, not in the future")
-	}
-	return nil
-}
-
-func (r *EventRequest) toEvent() Event {
+func (r *EventRequest) toEvent(timestamp time.Time) Event {
 	return Event{
+		EventID:    r.EventID,
 		EventName:  r.EventName,
 		Channel:    r.Channel,
 		CampaignID: r.CampaignID,
 		UserID:     r.UserID,
-		Timestamp:  r.Timestamp,
+		SessionID:  r.SessionID,
+		Timestamp:  timestamp,
 		Tags:       r.Tags,
 		Metadata:   r.Metadata,
 	}
 }
 
+const (
+	// TenantHeader names the tenant an event belongs to.
+	TenantHeader = "X-Tenant-ID"
+	// RequestIDHeader identifies the request. One is generated and echoed
+	// back when the client does not send it.
+	RequestIDHeader = "X-Request-ID"
+	// TraceParentHeader is the W3C trace context header.
+	TraceParentHeader = "traceparent"
+)
+
+func requestContext(c *gin.Context, receivedAt time.Time) context.Context {
+	requestID := c.GetHeader(RequestIDHeader)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	c.Header(RequestIDHeader, requestID)
+
+	// The tenant header is taken as sent: ingestion is unauthenticated, so
+	// it is only trustworthy when a proxy in front of the service sets it
+	// and drops any value from the client.
+	return WithRequestInfo(c.Request.Context(), RequestInfo{
+		ClientIP:    c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Tenant:      c.GetHeader(TenantHeader),
+		RequestID:   requestID,
+		TraceParent: c.GetHeader(TraceParentHeader),
+		ReceivedAt:  receivedAt,
+	})
+}
+
 type BulkEventRequest struct {
 	Events []EventRequest `json:"events" binding:"required,max=1000,dive"`
 }
@@ -61,6 +92,7 @@ type ErrorResponse struct {
 }
 
 func (h *Handler) PostEvent(c *gin.Context) {
+	receivedAt := time.Now()
 	var req EventRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -70,16 +102,17 @@ func (h *Handler) PostEvent(c *gin.Context) {
 		return
 	}
 
-	if err := validateTimestamp(req.Timestamp); err != nil {
+	timestamp, err := h.service.checkTimestamp(req.Timestamp.Time(), receivedAt)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: err.Error(),
 		})
 		return
 	}
 
-	event := req.toEvent()
+	event := req.toEvent(timestamp)
 
-	if err := h.service.ProcessEvent(c.Request.Context(), event); err != nil {
+	if err := h.service.ProcessEvent(requestContext(c, receivedAt), event); err != nil {
 		log.Printf("failed to process event: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "internal server error",
@@ -93,6 +126,7 @@ func (h *Handler) PostEvent(c *gin.Context) {
 }
 
 func (h *Handler) PostEventBulk(c *gin.Context) {
+	receivedAt := time.Now()
 	var req BulkEventRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -102,21 +136,19 @@ func (h *Handler) PostEventBulk(c *gin.Context) {
 		return
 	}
 
+	events := make([]Event, len(req.Events))
 	for i, r := range req.Events {
-		if err := validateTimestamp(r.Timestamp); err != nil {
+		timestamp, err := h.service.checkTimestamp(r.Timestamp.Time(), receivedAt)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{
 				Error: fmt.Sprintf("event[%d]: %s", i, err.Error()),
 			})
 			return
 		}
+		events[i] = r.toEvent(timestamp)
 	}
 
-	events := make([]Event, len(req.Events))
-	for i, r := range req.Events {
-		events[i] = r.toEvent()
-	}
-
-	if err := h.service.ProcessBulk(c.Request.Context(), events); err != nil {
+	if err := h.service.ProcessBulk(requestContext(c, receivedAt), events); err != nil {
 		log.Printf("failed to process bulk events: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "internal server error",
@@ -129,7 +161,71 @@ func (h *Handler) PostEventBulk(c *gin.Context) {
 	})
 }
 
+type TailQueryParams struct {
+	EventName string  `form:"event_name" binding:"omitempty"`
+	Channel   string  `form:"channel" binding:"omitempty,oneof=web mobile api email push"`
+	Sample    float64 `form:"sample" binding:"omitempty,gt=0,lte=1"`
+	Rate      int     `form:"rate" binding:"omitempty,gt=0"`
+}
+
+func (h *Handler) GetEventTail(c *gin.Context) {
+	var params TailQueryParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	events, unsubscribe, err := h.service.Tail(TailFilter{
+		EventName:  params.EventName,
+		Channel:    params.Channel,
+		SampleRate: params.Sample,
+		MaxRate:    params.Rate,
+	})
+	if errors.Is(err, ErrTooManyTailSubscribers) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	defer unsubscribe()
+
+	// Tails outlive the server's write timeout, so lift it for this request.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("failed to clear write deadline for event tail: %v", err)
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("event", event)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func (h *Handler) GetRedactionStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.RedactionStats())
+}
+
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	r.POST("/events", h.PostEvent)
 	r.POST("/events/bulk", h.PostEventBulk)
+	r.GET("/events/redactions", h.GetRedactionStats)
+}
+
+// RegisterAdminRoutes registers the routes that expose event payloads,
+// which belong behind admin authentication.
+func (h *Handler) RegisterAdminRoutes(r gin.IRoutes) {
+	r.GET("/events/tail", h.GetEventTail)
 }
@@ -3,19 +3,40 @@ package events
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/insider/event-ingestion/kafka"
 )
 
 type Event struct {
 	EventHash  uint64
+	EventID    string
 	EventName  string
 	Channel    string
 	CampaignID string
 	UserID     string
-	Timestamp  int64
+	SessionID  string
+	Timestamp  time.Time
 	Tags       []string
 	Metadata   map[string]any
+
+	ReceivedAt   time.Time
+	ClientIP     string
+	DeviceType   string
+	OS           string
+	Browser      string
+	Country      string
+	City         string
+	CampaignName string
+	Tenant       string
+	RequestID    string
+	TraceParent  string
+
+	Late bool
+
+	// SampleRate is the fraction of matching events kept by a sampling
+	// rule; zero means the event was not sampled.
+	SampleRate float64
 }
 
 func (e *Event) ToKafkaMessage() (kafka.EventMessage, error) {
@@ -24,14 +45,37 @@ func (e *Event) ToKafkaMessage() (kafka.EventMessage, error) {
 		return kafka.EventMessage{}, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
+	sampleRate := e.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
 	return kafka.EventMessage{
-		EventHash:  e.EventHash,
-		EventName:  e.EventName,
-		Channel:    e.Channel,
-		CampaignID: e.CampaignID,
-		UserID:     e.UserID,
-		Timestamp:  e.Timestamp,
-		Tags:       e.Tags,
-		Metadata:   string(metadataJSON),
+		EventHash:   e.EventHash,
+		EventID:     e.EventID,
+		EventName:   e.EventName,
+		Channel:     e.Channel,
+		CampaignID:  e.CampaignID,
+		UserID:      e.UserID,
+		SessionID:   e.SessionID,
+		Timestamp:   e.Timestamp.Unix(),
+		TimestampMs: e.Timestamp.UnixMilli(),
+		Tags:        e.Tags,
+		Metadata:    string(metadataJSON),
+		SampleRate:  sampleRate,
+
+		ReceivedAt:   e.ReceivedAt.UnixMilli(),
+		ClientIP:     e.ClientIP,
+		DeviceType:   e.DeviceType,
+		OS:           e.OS,
+		Browser:      e.Browser,
+		Country:      e.Country,
+		City:         e.City,
+		CampaignName: e.CampaignName,
+
+		Late:        e.Late,
+		Tenant:      e.Tenant,
+		RequestID:   e.RequestID,
+		TraceParent: e.TraceParent,
 	}, nil
 }
@@ -0,0 +1,319 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+const maskedValue = "***"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+	// Phone numbers are either international, starting with +, or national
+	// numbers written as groups of digits, such as "(555) 123-4567" or
+	// "0555 123 45 67". A bare run of digits is never taken for a phone
+	// number, since order IDs and timestamps look the same.
+	internationalPhonePattern = regexp.MustCompile(`\+\d[\d\s().\-]{6,}\d`)
+	nationalPhonePattern      = regexp.MustCompile(`\(?\d{2,4}\)?(?:[\s.\-]\d{2,4}){2,4}`)
+
+	datePattern      = regexp.MustCompile(`\d{4}[\-./]\d{1,2}[\-./]\d{1,2}|\d{1,2}[\-./]\d{1,2}[\-./]\d{4}`)
+	ipAddressPattern = regexp.MustCompile(`^\d{1,3}(?:\.\d{1,3}){3}$`)
+)
+
+// piiDetectors each return the byte ranges of the PII they find in a string.
+var piiDetectors = []func(string) [][]int{
+	findEmails,
+	findPhoneNumbers,
+}
+
+func findEmails(s string) [][]int {
+	return emailPattern.FindAllStringIndex(s, -1)
+}
+
+func findPhoneNumbers(s string) [][]int {
+	var found [][]int
+	for _, candidate := range internationalPhonePattern.FindAllStringIndex(s, -1) {
+		if isPhoneNumber(s, candidate, 8, 15) {
+			found = append(found, candidate)
+		}
+	}
+	for _, candidate := range nationalPhonePattern.FindAllStringIndex(s, -1) {
+		if isPhoneNumber(s, candidate, 10, 12) && !overlaps(found, candidate) {
+			found = append(found, candidate)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i][0] < found[j][0] })
+	return found
+}
+
+// isPhoneNumber reports whether the candidate stands on its own, rather
+// than being part of an identifier such as "ORD-2024-0001", has a plausible
+// number of digits, and is not a date or an IP address.
+func isPhoneNumber(s string, loc []int, minDigits, maxDigits int) bool {
+	if loc[0] > 0 && strings.ContainsRune(phoneNeighbours, rune(s[loc[0]-1])) {
+		return false
+	}
+	if loc[1] < len(s) && strings.ContainsRune(phoneNeighbours, rune(s[loc[1]])) {
+		return false
+	}
+
+	candidate := s[loc[0]:loc[1]]
+	digits := 0
+	for _, r := range candidate {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	if digits < minDigits || digits > maxDigits {
+		return false
+	}
+	return !datePattern.MatchString(candidate) && !ipAddressPattern.MatchString(candidate)
+}
+
+// phoneNeighbours are the characters that join a phone-like run of digits
+// to a longer token.
+const phoneNeighbours = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_-/:+#"
+
+func overlaps(ranges [][]int, loc []int) bool {
+	for _, r := range ranges {
+		if loc[0] < r[1] && r[0] < loc[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivacyPolicy strips personal data from events before they leave the
+// service. Listed metadata keys are dropped, masked or HMAC-hashed, string
+// values anywhere in metadata are scanned for emails and phone numbers, and
+// user IDs can be replaced by a keyed hash whose key rotates periodically.
+// A nil policy leaves events untouched.
+type PrivacyPolicy struct {
+	dropKeys       map[string]struct{}
+	maskKeys       map[string]struct{}
+	hashKeys       map[string]struct{}
+	detectPII      bool
+	detectedAction string
+
+	secret     []byte
+	hashUserID bool
+	rotation   time.Duration
+
+	dropped       atomic.Uint64
+	masked        atomic.Uint64
+	hashed        atomic.Uint64
+	userIDsHashed atomic.Uint64
+}
+
+type RedactionStats struct {
+	Dropped       uint64 `json:"dropped"`
+	Masked        uint64 `json:"masked"`
+	Hashed        uint64 `json:"hashed"`
+	UserIDsHashed uint64 `json:"user_ids_hashed"`
+}
+
+func NewPrivacyPolicy(cfg config.PrivacyConfig) (*PrivacyPolicy, error) {
+	switch cfg.DetectedAction {
+	case "drop", "mask", "hash":
+	default:
+		return nil, fmt.Errorf("invalid privacy detected_action: %s", cfg.DetectedAction)
+	}
+
+	needsSecret := cfg.HashUserID || len(cfg.HashKeys) > 0 || (cfg.DetectPII && cfg.DetectedAction == "hash")
+	if needsSecret && cfg.HashSecret == "" {
+		return nil, fmt.Errorf("privacy hash_secret is required for hashing")
+	}
+
+	return &PrivacyPolicy{
+		dropKeys:       keySet(cfg.DropKeys),
+		maskKeys:       keySet(cfg.MaskKeys),
+		hashKeys:       keySet(cfg.HashKeys),
+		detectPII:      cfg.DetectPII,
+		detectedAction: cfg.DetectedAction,
+		secret:         []byte(cfg.HashSecret),
+		hashUserID:     cfg.HashUserID,
+		rotation:       cfg.UserIDSaltRotation,
+	}, nil
+}
+
+func keySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[strings.ToLower(k)] = struct{}{}
+	}
+	return set
+}
+
+func (p *PrivacyPolicy) Apply(event *Event) {
+	if p == nil {
+		return
+	}
+
+	if event.Metadata != nil {
+		p.redactMap(event.Metadata)
+	}
+
+	if p.hashUserID && event.UserID != "" {
+		event.UserID = p.hashUserIDAt(event.UserID, event.Timestamp)
+		p.userIDsHashed.Add(1)
+	}
+}
+
+func (p *PrivacyPolicy) Stats() RedactionStats {
+	if p == nil {
+		return RedactionStats{}
+	}
+	return RedactionStats{
+		Dropped:       p.dropped.Load(),
+		Masked:        p.masked.Load(),
+		Hashed:        p.hashed.Load(),
+		UserIDsHashed: p.userIDsHashed.Load(),
+	}
+}
+
+func (p *PrivacyPolicy) redactMap(m map[string]any) {
+	for key, value := range m {
+		lower := strings.ToLower(key)
+		if _, ok := p.dropKeys[lower]; ok {
+			delete(m, key)
+			p.dropped.Add(1)
+			continue
+		}
+		if _, ok := p.maskKeys[lower]; ok {
+			m[key] = maskedValue
+			p.masked.Add(1)
+			continue
+		}
+		if _, ok := p.hashKeys[lower]; ok {
+			m[key] = p.hash(fmt.Sprint(value))
+			p.hashed.Add(1)
+			continue
+		}
+
+		redacted, drop := p.redactValue(value)
+		if drop {
+			delete(m, key)
+			p.dropped.Add(1)
+			continue
+		}
+		m[key] = redacted
+	}
+}
+
+// redactValue recurses into nested metadata and scans strings for PII. It
+// reports drop when the value contains PII and the policy drops such values.
+func (p *PrivacyPolicy) redactValue(value any) (any, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		p.redactMap(v)
+		return v, false
+	case []any:
+		kept := v[:0]
+		for _, item := range v {
+			redacted, drop := p.redactValue(item)
+			if drop {
+				p.dropped.Add(1)
+				continue
+			}
+			kept = append(kept, redacted)
+		}
+		return kept, false
+	case string:
+		if !p.detectPII {
+			return v, false
+		}
+		return p.redactString(v)
+	default:
+		return value, false
+	}
+}
+
+func (p *PrivacyPolicy) redactString(s string) (string, bool) {
+	for _, detect := range piiDetectors {
+		found := detect(s)
+		if len(found) == 0 {
+			continue
+		}
+		if p.detectedAction == "drop" {
+			return "", true
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, loc := range found {
+			b.WriteString(s[last:loc[0]])
+			if p.detectedAction == "hash" {
+				b.WriteString(p.hash(s[loc[0]:loc[1]]))
+				p.hashed.Add(1)
+			} else {
+				b.WriteString(maskedValue)
+				p.masked.Add(1)
+			}
+			last = loc[1]
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s, false
+}
+
+func (p *PrivacyPolicy) hash(value string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashUserIDAt hashes a user ID with a key derived from the secret and the
+// rotation period t falls in, so hashes stay stable within a period but
+// cannot be linked across periods.
+func (p *PrivacyPolicy) hashUserIDAt(userID string, t time.Time) string {
+	key := p.secret
+	if p.rotation > 0 {
+		var period [8]byte
+		binary.BigEndian.PutUint64(period[:], uint64(t.UnixNano()/int64(p.rotation)))
+		mac := hmac.New(sha256.New, p.secret)
+		mac.Write(period[:])
+		key = mac.Sum(nil)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// maxUserIDHashes bounds how many salts a user ID is looked up under.
+const maxUserIDHashes = 10000
+
+// UserIDHashes returns every form userID may be stored under for events
+// timestamped between from and to: the ID itself, as stored before hashing
+// was turned on, and its hash with each salt in use over that time.
+func (p *PrivacyPolicy) UserIDHashes(userID string, from, to time.Time) ([]string, error) {
+	ids := []string{userID}
+	if p == nil || !p.hashUserID {
+		return ids, nil
+	}
+	if p.rotation <= 0 {
+		return append(ids, p.hashUserIDAt(userID, from)), nil
+	}
+
+	first := from.UnixNano() / int64(p.rotation)
+	last := to.UnixNano() / int64(p.rotation)
+	if last-first >= maxUserIDHashes {
+		return nil, fmt.Errorf("user ID salt rotation %s is too short to look up events from %s to %s", p.rotation, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	for period := first; period <= last; period++ {
+		ids = append(ids, p.hashUserIDAt(userID, time.Unix(0, period*int64(p.rotation))))
+	}
+	return ids, nil
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+func newTestPolicy(t *testing.T) *PrivacyPolicy {
+	t.Helper()
+	policy, err := NewPrivacyPolicy(config.PrivacyConfig{DetectPII: true, DetectedAction: "mask"})
+	if err != nil {
+		t.Fatalf("NewPrivacyPolicy() error = %v", err)
+	}
+	return policy
+}
+
+func TestRedactStringMasksPII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"contact jane.doe@example.com", "contact ***"},
+		{"+90 555 123 45 67", "***"},
+		{"call +1 (555) 123-4567 today", "call *** today"},
+		{"call +442071234567 now", "call *** now"},
+		{"555-123-4567", "***"},
+		{"(555) 123 4567", "***"},
+		{"0555 123 45 67", "***"},
+		{"555.123.4567.", "***."},
+	}
+	policy := newTestPolicy(t)
+	for _, tt := range tests {
+		if got, _ := policy.redactString(tt.in); got != tt.want {
+			t.Errorf("redactString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactStringIgnoresPhoneLookalikes(t *testing.T) {
+	tests := []string{
+		"2024-01-15",
+		"2024-01-15T10:30:00Z",
+		"2024-01-15 10:30:00",
+		"15.01.2024",
+		"15.01.2024 10.30",
+		"01/15/2024",
+		"ORD-2024-000123",
+		"order 1234567890123",
+		"INV-555-123-4567",
+		"price 1299.99",
+		"total 1,299,999.00",
+		"192.168.100.200",
+		"1705312200000",
+		"v1.2.3",
+		"sku 12-3456",
+	}
+	policy := newTestPolicy(t)
+	for _, in := range tests {
+		if got, _ := policy.redactString(in); got != in {
+			t.Errorf("redactString(%q) = %q, want it unchanged", in, got)
+		}
+	}
+}
+
+func TestRedactStringDrop(t *testing.T) {
+	policy, err := NewPrivacyPolicy(config.PrivacyConfig{DetectPII: true, DetectedAction: "drop"})
+	if err != nil {
+		t.Fatalf("NewPrivacyPolicy() error = %v", err)
+	}
+	if _, drop := policy.redactString("call 555-123-4567"); !drop {
+		t.Error("redactString() did not drop a value with a phone number")
+	}
+	if _, drop := policy.redactString("2024-01-15"); drop {
+		t.Error("redactString() dropped a date")
+	}
+}
+
+func TestUserIDHashesCoverEveryPeriod(t *testing.T) {
+	policy, err := NewPrivacyPolicy(config.PrivacyConfig{
+		DetectedAction:     "mask",
+		HashSecret:         "secret",
+		HashUserID:         true,
+		UserIDSaltRotation: 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewPrivacyPolicy() error = %v", err)
+	}
+
+	from := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(72 * time.Hour)
+	ids, err := policy.UserIDHashes("user-1", from, to)
+	if err != nil {
+		t.Fatalf("UserIDHashes() error = %v", err)
+	}
+	if len(ids) != 5 {
+		t.Fatalf("got %d IDs, want the raw ID and 4 hashes", len(ids))
+	}
+
+	stored := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		stored[id] = true
+	}
+	for ts := from; !ts.After(to); ts = ts.Add(6 * time.Hour) {
+		event := &Event{UserID: "user-1", Timestamp: ts}
+		policy.Apply(event)
+		if !stored[event.UserID] {
+			t.Errorf("hash for an event at %s is not looked up", ts)
+		}
+	}
+}
+
+func TestUserIDHashesWithoutHashing(t *testing.T) {
+	var policy *PrivacyPolicy
+	ids, err := policy.UserIDHashes("user-1", time.Now(), time.Now())
+	if err != nil || len(ids) != 1 || ids[0] != "user-1" {
+		t.Errorf("UserIDHashes() = %v, %v, want just the raw ID", ids, err)
+	}
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	RuleActionDrop   = "drop"
+	RuleActionSample = "sample"
+)
+
+// Rule matches events by event name, channel and tag, all optional, and
+// drops or samples the events it matches. Choosing a topic is left to the
+// producer's routes, which only name topics that ClickHouse consumes.
+type Rule struct {
+	Name       string  `json:"name"`
+	EventName  string  `json:"event_name"`
+	Channel    string  `json:"channel"`
+	Tag        string  `json:"tag"`
+	Action     string  `json:"action"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+func (r *Rule) validate() error {
+	switch r.Action {
+	case RuleActionDrop:
+	case RuleActionSample:
+		if r.SampleRate <= 0 || r.SampleRate > 1 {
+			return fmt.Errorf("rule %q: sample_rate must be in (0, 1]", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %q: unsupported action: %s", r.Name, r.Action)
+	}
+	return nil
+}
+
+func (r *Rule) matches(event *Event) bool {
+	if r.EventName != "" && event.EventName != r.EventName {
+		return false
+	}
+	if r.Channel != "" && event.Channel != r.Channel {
+		return false
+	}
+	if r.Tag != "" && !slices.Contains(event.Tags, r.Tag) {
+		return false
+	}
+	return true
+}
+
+// Rules applies the first matching rule from a JSON rules file to each
+// event. The file is polled for changes and reloaded in place; a file that
+// fails to load leaves the previous rules active. A nil Rules keeps every
+// event.
+type Rules struct {
+	path  string
+	rules atomic.Pointer[[]Rule]
+
+	modTime time.Time
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewRules(path string, reloadInterval time.Duration) (*Rules, error) {
+	r := &Rules{
+		path: path,
+		done: make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		r.wg.Add(1)
+		go r.watch(reloadInterval)
+	}
+
+	return r, nil
+}
+
+func (r *Rules) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat rules file: %w", err)
+	}
+	if info.ModTime().Equal(r.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse rules file: %w", err)
+	}
+	for i := range file.Rules {
+		if err := file.Rules[i].validate(); err != nil {
+			return fmt.Errorf("invalid rules file: %w", err)
+		}
+	}
+
+	r.rules.Store(&file.Rules)
+	r.modTime = info.ModTime()
+	return nil
+}
+
+func (r *Rules) watch(interval time.Duration) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			if err := r.load(); err != nil {
+				log.Printf("failed to reload rules, keeping previous rules: %v", err)
+			}
+		}
+	}
+}
+
+// Apply runs the first rule matching event and reports whether the event
+// should be kept. Sampling hashes the user ID, so a user is either always
+// kept or always dropped by the same rule, and kept events carry the sample
+// rate so counts can be scaled back up.
+func (r *Rules) Apply(event *Event) bool {
+	if r == nil {
+		return true
+	}
+
+	for _, rule := range *r.rules.Load() {
+		if !rule.matches(event) {
+			continue
+		}
+		switch rule.Action {
+		case RuleActionDrop:
+			return false
+		case RuleActionSample:
+			if !sampled(rule.Name, event.UserID, rule.SampleRate) {
+				return false
+			}
+			event.SampleRate = rule.SampleRate
+		}
+		return true
+	}
+	return true
+}
+
+// sampled salts the user ID hash with the rule name so that rules with the
+// same rate do not all keep the same users.
+func sampled(ruleName, userID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	h := xxhash.Sum64String(ruleName + "\x00" + userID)
+	return float64(h) < rate*math.MaxUint64
+}
+
+// Close stops watching the rules file.
+func (r *Rules) Close() error {
+	close(r.done)
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSampledIsDeterministic(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		first := sampled("sample-scroll", userID, 0.3)
+		for j := 0; j < 5; j++ {
+			if sampled("sample-scroll", userID, 0.3) != first {
+				t.Fatalf("sampled() changed its decision for %s", userID)
+			}
+		}
+	}
+}
+
+func TestSampledKeepsRate(t *testing.T) {
+	const users = 20000
+	kept := 0
+	for i := 0; i < users; i++ {
+		if sampled("sample-scroll", fmt.Sprintf("user-%d", i), 0.25) {
+			kept++
+		}
+	}
+	if rate := float64(kept) / users; rate < 0.23 || rate > 0.27 {
+		t.Errorf("kept %.3f of users, want about 0.25", rate)
+	}
+}
+
+func TestSampledBounds(t *testing.T) {
+	if !sampled("rule", "user-1", 1) {
+		t.Error("sampled() dropped a user at rate 1")
+	}
+	for i := 0; i < 1000; i++ {
+		if sampled("rule", fmt.Sprintf("user-%d", i), 0) {
+			t.Fatal("sampled() kept a user at rate 0")
+		}
+	}
+}
+
+func TestSampledSaltsByRule(t *testing.T) {
+	same := 0
+	for i := 0; i < 1000; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		if sampled("rule-a", userID, 0.5) == sampled("rule-b", userID, 0.5) {
+			same++
+		}
+	}
+	if same > 600 {
+		t.Errorf("rules agreed on %d of 1000 users, want them to sample independently", same)
+	}
+}
+
+func writeRules(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRulesApply(t *testing.T) {
+	rules, err := NewRules(writeRules(t, `{"rules": [
+		{"name": "drop-heartbeats", "event_name": "heartbeat", "action": "drop"},
+		{"name": "keep-all-scrolls", "event_name": "scroll", "tag": "vip", "action": "sample", "sample_rate": 1},
+		{"name": "sample-scroll", "event_name": "scroll", "action": "sample", "sample_rate": 0.5}
+	]}`), 0)
+	if err != nil {
+		t.Fatalf("NewRules() error = %v", err)
+	}
+	defer rules.Close()
+
+	if rules.Apply(&Event{EventName: "heartbeat"}) {
+		t.Error("heartbeat was kept")
+	}
+	if !rules.Apply(&Event{EventName: "purchase"}) {
+		t.Error("an event without a matching rule was dropped")
+	}
+
+	vip := &Event{EventName: "scroll", UserID: "user-1", Tags: []string{"vip"}}
+	if !rules.Apply(vip) || vip.SampleRate != 1 {
+		t.Errorf("first matching rule not applied, sample rate %v", vip.SampleRate)
+	}
+
+	for i := 0; i < 100; i++ {
+		event := &Event{EventName: "scroll", UserID: fmt.Sprintf("user-%d", i)}
+		kept := rules.Apply(event)
+		if kept != sampled("sample-scroll", event.UserID, 0.5) {
+			t.Fatalf("Apply() disagrees with sampled() for %s", event.UserID)
+		}
+		if kept && event.SampleRate != 0.5 {
+			t.Errorf("kept event has sample rate %v, want 0.5", event.SampleRate)
+		}
+	}
+}
+
+func TestRulesRejectInvalidFiles(t *testing.T) {
+	tests := map[string]string{
+		"route action":       `{"rules": [{"name": "r", "action": "route", "topic": "events.debug"}]}`,
+		"sample rate zero":   `{"rules": [{"name": "r", "action": "sample", "sample_rate": 0}]}`,
+		"sample rate over 1": `{"rules": [{"name": "r", "action": "sample", "sample_rate": 1.5}]}`,
+		"malformed":          `{"rules": [`,
+	}
+	for name, body := range tests {
+		if _, err := NewRules(writeRules(t, body), 0); err == nil {
+			t.Errorf("%s: NewRules() succeeded", name)
+		}
+	}
+}
+
+func TestNilRulesKeepEverything(t *testing.T) {
+	var rules *Rules
+	if !rules.Apply(&Event{EventName: "anything"}) {
+		t.Error("nil rules dropped an event")
+	}
+}
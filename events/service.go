@@ -2,10 +2,14 @@ package events
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
+	"github.com/insider/event-ingestion/config"
 	"github.com/insider/event-ingestion/kafka"
 )
 
@@ -14,18 +18,140 @@ type eventPublisher interface {
 	PublishBulk(ctx context.Context, msgs []kafka.EventMessage) error
 }
 
+// Sink receives every event this instance publishes, after it was written
+// to Kafka. Send is called on the request path and must not block.
+type Sink interface {
+	Send(msgs ...kafka.EventMessage)
+}
+
+const (
+	LatePolicyAccept = "accept"
+	LatePolicyRoute  = "route"
+	LatePolicyReject = "reject"
+)
+
+// dedupFields are the event fields that can make up the dedup hash of
+// events sent without an event_id.
+var dedupFields = map[string]struct{}{
+	"event_name":  {},
+	"user_id":     {},
+	"timestamp":   {},
+	"channel":     {},
+	"campaign_id": {},
+	"tags":        {},
+	"metadata":    {},
+}
+
 type Service struct {
-	publisher eventPublisher
+	publisher   eventPublisher
+	enrichers   []Enricher
+	privacy     *PrivacyPolicy
+	rules       *Rules
+	tap         *Tap
+	sinks       []Sink
+	dedupFields []string
+
+	futureSkew    time.Duration
+	maxAge        time.Duration
+	latePolicy    string
+	lateThreshold time.Duration
 }
 
-func NewService(publisher eventPublisher) *Service {
+func NewService(publisher eventPublisher, cfg config.EventsConfig, enrichers []Enricher, privacy *PrivacyPolicy, rules *Rules, sinks ...Sink) (*Service, error) {
+	if len(cfg.DedupFields) == 0 {
+		return nil, fmt.Errorf("at least one dedup field is required")
+	}
+	for _, field := range cfg.DedupFields {
+		if _, ok := dedupFields[field]; !ok {
+			return nil, fmt.Errorf("unsupported dedup field: %s", field)
+		}
+	}
+
+	switch cfg.LatePolicy {
+	case LatePolicyAccept, LatePolicyRoute, LatePolicyReject:
+	default:
+		return nil, fmt.Errorf("unsupported late policy: %s", cfg.LatePolicy)
+	}
+
 	return &Service{
-		publisher: publisher,
+		publisher:     publisher,
+		enrichers:     enrichers,
+		privacy:       privacy,
+		rules:         rules,
+		tap:           NewTap(cfg.TailMaxRate, cfg.TailMaxSubscribers),
+		sinks:         sinks,
+		dedupFields:   cfg.DedupFields,
+		futureSkew:    cfg.FutureSkewTolerance,
+		maxAge:        cfg.MaxAge,
+		latePolicy:    cfg.LatePolicy,
+		lateThreshold: cfg.LateThreshold,
+	}, nil
+}
+
+// checkTimestamp validates a client timestamp against the time the server
+// received it. Timestamps up to the skew tolerance in the future are
+// accepted as client clock drift and stored as sent, so the drift stays
+// visible next to received_at. Late events are rejected here when the late
+// policy says so.
+func (s *Service) checkTimestamp(ts, receivedAt time.Time) (time.Time, error) {
+	if ts.UnixMilli() <= 0 {
+		return time.Time{}, fmt.Errorf("invalid timestamp: must be positive")
+	}
+	if ts.After(receivedAt.Add(s.futureSkew)) {
+		return time.Time{}, fmt.Errorf("invalid timestamp: more than %s in the future", s.futureSkew)
+	}
+	if s.maxAge > 0 && ts.Before(receivedAt.Add(-s.maxAge)) {
+		return time.Time{}, fmt.Errorf("invalid timestamp: older than %s", s.maxAge)
+	}
+	if s.latePolicy == LatePolicyReject && s.isLate(ts, receivedAt) {
+		return time.Time{}, fmt.Errorf("invalid timestamp: late events older than %s are rejected", s.lateThreshold)
+	}
+
+	return ts, nil
+}
+
+// RedactionStats reports how much personal data the privacy policy removed.
+func (s *Service) RedactionStats() RedactionStats {
+	return s.privacy.Stats()
+}
+
+// Tail subscribes to events published by this instance. See Tap.Subscribe.
+func (s *Service) Tail(filter TailFilter) (<-chan kafka.EventMessage, func(), error) {
+	return s.tap.Subscribe(filter)
+}
+
+// CloseTails ends all open tails. Tails never go idle, so they are ended as
+// soon as the server starts shutting down, before the service is closed.
+func (s *Service) CloseTails() {
+	s.tap.Close()
+}
+
+// Close ends all open tails, stops reloading rules and releases resources
+// held by enrichers.
+func (s *Service) Close() {
+	s.tap.Close()
+	if s.rules != nil {
+		s.rules.Close()
 	}
+	for _, e := range s.enrichers {
+		if c, ok := e.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func (s *Service) isLate(ts, receivedAt time.Time) bool {
+	return s.lateThreshold > 0 && receivedAt.Sub(ts) > s.lateThreshold
 }
 
 func (s *Service) ProcessEvent(ctx context.Context, event Event) error {
-	event.EventHash = generateEventHash(event.EventName, event.UserID, event.Timestamp)
+	s.enrich(ctx, &event)
+	if !s.rules.Apply(&event) {
+		return nil
+	}
+	s.markLate(&event)
+	s.privacy.Apply(&event)
+	event.EventHash = s.generateEventHash(&event)
 	msg, err := event.ToKafkaMessage()
 	if err != nil {
 		return fmt.Errorf("failed to convert event to kafka message: %w", err)
@@ -34,33 +160,97 @@ func (s *Service) ProcessEvent(ctx context.Context, event Event) error {
 	if err := s.publisher.Publish(ctx, msg); err != nil {
 		return fmt.Errorf("failed to publish event: %w", err)
 	}
+	s.emit(msg)
 	return nil
 }
 
 func (s *Service) ProcessBulk(ctx context.Context, events []Event) error {
-	msgs := make([]kafka.EventMessage, len(events))
+	msgs := make([]kafka.EventMessage, 0, len(events))
 	for i := range events {
-		events[i].EventHash = generateEventHash(events[i].EventName, events[i].UserID, events[i].Timestamp)
+		s.enrich(ctx, &events[i])
+		if !s.rules.Apply(&events[i]) {
+			continue
+		}
+		s.markLate(&events[i])
+		s.privacy.Apply(&events[i])
+		events[i].EventHash = s.generateEventHash(&events[i])
 		msg, err := events[i].ToKafkaMessage()
 		if err != nil {
 			return fmt.Errorf("failed to convert event at index %d to kafka message: %w", i, err)
 		}
-		msgs[i] = msg
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil
 	}
 
 	if err := s.publisher.PublishBulk(ctx, msgs); err != nil {
 		return fmt.Errorf("failed to publish events: %w", err)
 	}
+	s.emit(msgs...)
 	return nil
 }
 
-func generateEventHash(eventName, userID string, timestamp int64) uint64 {
+func (s *Service) emit(msgs ...kafka.EventMessage) {
+	s.tap.Emit(msgs...)
+	for _, sink := range s.sinks {
+		sink.Send(msgs...)
+	}
+}
+
+// markLate flags events that arrived later than the late threshold so the
+// publisher routes them away from the main topic. It relies on ReceivedAt,
+// which the receive-time enricher always sets.
+func (s *Service) markLate(event *Event) {
+	if s.latePolicy == LatePolicyRoute {
+		event.Late = s.isLate(event.Timestamp, event.ReceivedAt)
+	}
+}
+
+func (s *Service) enrich(ctx context.Context, event *Event) {
+	for _, e := range s.enrichers {
+		e.Enrich(ctx, event)
+	}
+}
+
+// generateEventHash returns the dedup key of an event. A client-supplied
+// event ID identifies the event on its own, so retries are deduplicated even
+// if other fields changed; otherwise the configured fields are hashed.
+func (s *Service) generateEventHash(event *Event) uint64 {
+	if event.EventID != "" {
+		return xxhash.Sum64String(event.EventID)
+	}
+
 	var buf [128]byte
 	b := buf[:0]
-	b = append(b, eventName...)
-	b = append(b, ':')
-	b = append(b, userID...)
-	b = append(b, ':')
-	b = strconv.AppendInt(b, timestamp, 10)
+	for i, field := range s.dedupFields {
+		if i > 0 {
+			b = append(b, ':')
+		}
+		switch field {
+		case "event_name":
+			b = append(b, event.EventName...)
+		case "user_id":
+			b = append(b, event.UserID...)
+		case "timestamp":
+			b = strconv.AppendInt(b, event.Timestamp.UnixMilli(), 10)
+		case "channel":
+			b = append(b, event.Channel...)
+		case "campaign_id":
+			b = append(b, event.CampaignID...)
+		case "tags":
+			for j, tag := range event.Tags {
+				if j > 0 {
+					b = append(b, 0)
+				}
+				b = append(b, tag...)
+			}
+		case "metadata":
+			// json.Marshal sorts map keys, so equal metadata hashes equally.
+			metadataJSON, _ := json.Marshal(event.Metadata)
+			b = append(b, metadataJSON...)
+		}
+	}
 	return xxhash.Sum64(b)
 }
@@ -0,0 +1,39 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckTimestamp(t *testing.T) {
+	s := &Service{
+		futureSkew:    5 * time.Minute,
+		maxAge:        30 * 24 * time.Hour,
+		latePolicy:    LatePolicyReject,
+		lateThreshold: 24 * time.Hour,
+	}
+	receivedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		ts      time.Time
+		wantErr bool
+	}{
+		{"on time", receivedAt.Add(-time.Second), false},
+		{"drift within tolerance", receivedAt.Add(2 * time.Minute), false},
+		{"too far in the future", receivedAt.Add(10 * time.Minute), true},
+		{"late", receivedAt.Add(-48 * time.Hour), true},
+		{"too old", receivedAt.Add(-60 * 24 * time.Hour), true},
+		{"not positive", time.UnixMilli(0), true},
+	}
+	for _, tt := range tests {
+		got, err := s.checkTimestamp(tt.ts, receivedAt)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkTimestamp() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !got.Equal(tt.ts) {
+			t.Errorf("%s: checkTimestamp() = %s, want the timestamp as sent %s", tt.name, got, tt.ts)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/insider/event-ingestion/kafka"
+)
+
+var ErrTooManyTailSubscribers = errors.New("too many tail subscribers")
+
+type TailFilter struct {
+	EventName  string
+	Channel    string
+	SampleRate float64
+	MaxRate    int
+}
+
+// Tap lets debugging clients watch published events in real time. Publishing
+// never waits on subscribers: events are sampled, rate limited and dropped
+// when a subscriber falls behind. Each instance only sees the events it
+// published itself.
+type Tap struct {
+	maxRate        int
+	maxSubscribers int
+
+	active      atomic.Int32
+	mu          sync.Mutex
+	subscribers map[*tailSubscriber]struct{}
+	closed      bool
+}
+
+type tailSubscriber struct {
+	filter TailFilter
+	ch     chan kafka.EventMessage
+
+	windowStart time.Time
+	windowCount int
+}
+
+func NewTap(maxRate, maxSubscribers int) *Tap {
+	return &Tap{
+		maxRate:        maxRate,
+		maxSubscribers: maxSubscribers,
+		subscribers:    make(map[*tailSubscriber]struct{}),
+	}
+}
+
+// Subscribe registers a tail for events matching filter. MaxRate is clamped
+// to the tap's hard cap. The returned channel is closed when the tap shuts
+// down; callers must invoke the returned function once they stop reading.
+func (t *Tap) Subscribe(filter TailFilter) (<-chan kafka.EventMessage, func(), error) {
+	if filter.MaxRate <= 0 || filter.MaxRate > t.maxRate {
+		filter.MaxRate = t.maxRate
+	}
+	if filter.SampleRate <= 0 || filter.SampleRate > 1 {
+		filter.SampleRate = 1
+	}
+
+	sub := &tailSubscriber{
+		filter: filter,
+		ch:     make(chan kafka.EventMessage, filter.MaxRate),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		close(sub.ch)
+		return sub.ch, func() {}, nil
+	}
+	if len(t.subscribers) >= t.maxSubscribers {
+		return nil, nil, ErrTooManyTailSubscribers
+	}
+
+	t.subscribers[sub] = struct{}{}
+	t.active.Add(1)
+
+	return sub.ch, func() { t.unsubscribe(sub) }, nil
+}
+
+func (t *Tap) unsubscribe(sub *tailSubscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.subscribers[sub]; !ok {
+		return
+	}
+	delete(t.subscribers, sub)
+	t.active.Add(-1)
+	close(sub.ch)
+}
+
+// Close ends every open tail.
+func (t *Tap) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for sub := range t.subscribers {
+		delete(t.subscribers, sub)
+		close(sub.ch)
+	}
+	t.active.Store(0)
+}
+
+// Emit offers published messages to the current subscribers. It is a no-op
+// without subscribers so that the ingestion hot path pays nothing for it.
+func (t *Tap) Emit(msgs ...kafka.EventMessage) {
+	if t == nil || t.active.Load() == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for sub := range t.subscribers {
+		for _, msg := range msgs {
+			if !sub.accepts(msg, now) {
+				continue
+			}
+			select {
+			case sub.ch <- msg:
+			default:
+			}
+		}
+	}
+}
+
+func (s *tailSubscriber) accepts(msg kafka.EventMessage, now time.Time) bool {
+	if s.filter.EventName != "" && msg.EventName != s.filter.EventName {
+		return false
+	}
+	if s.filter.Channel != "" && msg.Channel != s.filter.Channel {
+		return false
+	}
+	if s.filter.SampleRate < 1 && rand.Float64() >= s.filter.SampleRate {
+		return false
+	}
+
+	if now.Sub(s.windowStart) >= time.Second {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+	if s.windowCount >= s.filter.MaxRate {
+		return false
+	}
+	s.windowCount++
+
+	return true
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// millisecondThreshold separates Unix seconds from Unix milliseconds in
+// numeric timestamps. As seconds it is in the year 5138; as milliseconds it
+// is in 1973, before any event this service will see.
+const millisecondThreshold = 100_000_000_000
+
+// Timestamp is an event time in Unix milliseconds. In JSON it accepts Unix
+// seconds (optionally fractional), Unix milliseconds, or an RFC3339 string.
+type Timestamp int64
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp: must be Unix seconds, Unix milliseconds or RFC3339")
+		}
+		*t = Timestamp(parsed.UnixMilli())
+		return nil
+	}
+
+	if n, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		if n > -millisecondThreshold && n < millisecondThreshold {
+			n *= 1000
+		}
+		*t = Timestamp(n)
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || math.Abs(f) >= millisecondThreshold {
+		return fmt.Errorf("invalid timestamp: must be Unix seconds, Unix milliseconds or RFC3339")
+	}
+	*t = Timestamp(math.Round(f * 1000))
+	return nil
+}
+
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(int64(t))
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTimestampUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: `1700000000`, want: 1700000000000},
+		{in: `1700000000123`, want: 1700000000123},
+		{in: `1700000000.5`, want: 1700000000500},
+		{in: `1700000000.1234`, want: 1700000000123},
+		{in: `"2023-11-14T22:13:20Z"`, want: 1700000000000},
+		{in: `"2023-11-14T22:13:20.250Z"`, want: 1700000000250},
+		{in: `"2023-11-15T01:13:20+03:00"`, want: 1700000000000},
+		{in: `99999999999`, want: 99999999999000},
+		{in: `100000000000`, want: 100000000000},
+		{in: `null`, want: 0},
+		{in: `"2023-11-14"`, wantErr: true},
+		{in: `"yesterday"`, wantErr: true},
+		{in: `1e12`, wantErr: true},
+		{in: `true`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var ts Timestamp
+		err := json.Unmarshal([]byte(tt.in), &ts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && int64(ts) != tt.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, ts, tt.want)
+		}
+	}
+}
+
+func TestTimestampTime(t *testing.T) {
+	if got := Timestamp(1700000000250).Time().UnixMilli(); got != 1700000000250 {
+		t.Errorf("Time().UnixMilli() = %d", got)
+	}
+}
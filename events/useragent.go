@@ -0,0 +1,75 @@
+package events
+
+import "strings"
+
+type userAgent struct {
+	device  string
+	os      string
+	browser string
+}
+
+// parseUserAgent classifies a User-Agent header into coarse device, OS and
+// browser families. It deliberately only recognises the common families;
+// anything else is reported as "other".
+func parseUserAgent(header string) userAgent {
+	if header == "" {
+		return userAgent{}
+	}
+
+	ua := strings.ToLower(header)
+	return userAgent{
+		device:  parseDevice(ua),
+		os:      parseOS(ua),
+		browser: parseBrowser(ua),
+	}
+}
+
+func parseDevice(ua string) string {
+	switch {
+	case strings.Contains(ua, "bot"), strings.Contains(ua, "crawler"), strings.Contains(ua, "spider"):
+		return "bot"
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return "tablet"
+	case strings.Contains(ua, "mobile"), strings.Contains(ua, "iphone"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "windows"):
+		return "windows"
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		return "ios"
+	case strings.Contains(ua, "mac os x"), strings.Contains(ua, "macintosh"):
+		return "macos"
+	case strings.Contains(ua, "android"):
+		return "android"
+	case strings.Contains(ua, "cros"):
+		return "chromeos"
+	case strings.Contains(ua, "linux"):
+		return "linux"
+	default:
+		return "other"
+	}
+}
+
+func parseBrowser(ua string) string {
+	switch {
+	case strings.Contains(ua, "edg/"):
+		return "edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		return "opera"
+	case strings.Contains(ua, "firefox/"), strings.Contains(ua, "fxios/"):
+		return "firefox"
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"):
+		return "chrome"
+	case strings.Contains(ua, "safari/"):
+		return "safari"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"os"
+	"strconv"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// SchemaVersion is the version of the EventMessage payload. Bump it when a
+// change to the payload is not backwards compatible.
+const SchemaVersion = "1"
+
+const (
+	HeaderContentType   = "content-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderProducerID    = "producer-id"
+	HeaderReceivedAt    = "received-at"
+	HeaderRequestID     = "request-id"
+	HeaderTraceParent   = "traceparent"
+	HeaderTenant        = "tenant-id"
+)
+
+// instanceID identifies this producer in message headers, defaulting to the
+// host name, which is the container ID under Docker.
+func instanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "unknown"
+}
+
+// headers describe a message so that consumers can route and debug it
+// without parsing the payload. Headers without a value are left out.
+func (p *Producer) headers(msg *EventMessage) []kafkago.Header {
+	headers := []kafkago.Header{
+		{Key: HeaderContentType, Value: []byte("application/json")},
+		{Key: HeaderSchemaVersion, Value: []byte(SchemaVersion)},
+		{Key: HeaderProducerID, Value: []byte(p.instanceID)},
+	}
+
+	if msg.ReceivedAt > 0 {
+		headers = append(headers, kafkago.Header{Key: HeaderReceivedAt, Value: strconv.AppendInt(nil, msg.ReceivedAt, 10)})
+	}
+	if msg.RequestID != "" {
+		headers = append(headers, kafkago.Header{Key: HeaderRequestID, Value: []byte(msg.RequestID)})
+	}
+	if msg.TraceParent != "" {
+		headers = append(headers, kafkago.Header{Key: HeaderTraceParent, Value: []byte(msg.TraceParent)})
+	}
+	if msg.Tenant != "" {
+		headers = append(headers, kafkago.Header{Key: HeaderTenant, Value: []byte(msg.Tenant)})
+	}
+
+	return headers
+}
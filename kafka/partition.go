@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	PartitionKeyEventHash = "event_hash"
+	PartitionKeyUserID    = "user_id"
+	PartitionKeyNone      = "none"
+
+	BalancerLeastBytes = "least_bytes"
+	BalancerHash       = "hash"
+	BalancerMurmur2    = "murmur2"
+	BalancerRoundRobin = "round_robin"
+	BalancerSticky     = "sticky"
+)
+
+// stickyBatchSize is how many messages the sticky balancer sends to one
+// partition before moving to the next.
+const stickyBatchSize = 1000
+
+// messageKey returns the key a message is written with. Keying by user ID
+// with a hashing balancer keeps each user's events in one partition, in
+// order.
+func messageKey(partitionKey string, msg *EventMessage) []byte {
+	switch partitionKey {
+	case PartitionKeyUserID:
+		return []byte(msg.UserID)
+	case PartitionKeyNone:
+		return nil
+	default:
+		return strconv.AppendUint(nil, msg.EventHash, 10)
+	}
+}
+
+// newBalancer validates the partitioning settings and returns a factory for
+// per-writer balancers, since stateful balancers must not be shared.
+func newBalancer(partitionKey, balancer string) (func() kafkago.Balancer, error) {
+	switch partitionKey {
+	case PartitionKeyEventHash, PartitionKeyNone:
+	case PartitionKeyUserID:
+		// Keying by user only keeps a user's events together when the
+		// balancer hashes the key; any other balancer ignores it.
+		if balancer != BalancerHash && balancer != BalancerMurmur2 {
+			return nil, fmt.Errorf("kafka partition key %s requires the %s or %s balancer, got %s", partitionKey, BalancerHash, BalancerMurmur2, balancer)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kafka partition key: %s", partitionKey)
+	}
+
+	switch balancer {
+	case BalancerLeastBytes:
+		return func() kafkago.Balancer { return &kafkago.LeastBytes{} }, nil
+	case BalancerRoundRobin:
+		return func() kafkago.Balancer { return &kafkago.RoundRobin{} }, nil
+	case BalancerSticky:
+		return func() kafkago.Balancer { return &stickyBalancer{} }, nil
+	case BalancerHash, BalancerMurmur2:
+		if partitionKey == PartitionKeyNone {
+			return nil, fmt.Errorf("kafka balancer %s requires a partition key", balancer)
+		}
+		if balancer == BalancerMurmur2 {
+			// Matches the Java client's default partitioner, so other
+			// producers keyed the same way land on the same partitions.
+			return func() kafkago.Balancer { return kafkago.Murmur2Balancer{} }, nil
+		}
+		return func() kafkago.Balancer { return &kafkago.Hash{} }, nil
+	default:
+		return nil, fmt.Errorf("unsupported kafka balancer: %s", balancer)
+	}
+}
+
+// stickyBalancer sends consecutive messages to the same partition so they
+// fill fewer, larger batches, moving on every stickyBatchSize messages to
+// spread load over time.
+type stickyBalancer struct {
+	mu        sync.Mutex
+	partition int
+	count     int
+}
+
+func (b *stickyBalancer) Balance(_ kafkago.Message, partitions ...int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.count >= stickyBatchSize {
+		b.partition++
+		b.count = 0
+	}
+	b.count++
+
+	return partitions[b.partition%len(partitions)]
+}
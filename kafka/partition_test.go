@@ -0,0 +1,29 @@
+package kafka
+
+import "testing"
+
+func TestNewBalancer(t *testing.T) {
+	tests := []struct {
+		partitionKey string
+		balancer     string
+		wantErr      bool
+	}{
+		{PartitionKeyEventHash, BalancerLeastBytes, false},
+		{PartitionKeyEventHash, BalancerMurmur2, false},
+		{PartitionKeyUserID, BalancerHash, false},
+		{PartitionKeyUserID, BalancerMurmur2, false},
+		{PartitionKeyUserID, BalancerLeastBytes, true},
+		{PartitionKeyUserID, BalancerRoundRobin, true},
+		{PartitionKeyUserID, BalancerSticky, true},
+		{PartitionKeyNone, BalancerRoundRobin, false},
+		{PartitionKeyNone, BalancerHash, true},
+		{"tenant", BalancerHash, true},
+		{PartitionKeyEventHash, "random", true},
+	}
+	for _, tt := range tests {
+		_, err := newBalancer(tt.partitionKey, tt.balancer)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newBalancer(%s, %s) error = %v, wantErr %v", tt.partitionKey, tt.balancer, err, tt.wantErr)
+		}
+	}
+}
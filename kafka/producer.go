@@ -3,81 +3,292 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"sync"
 
 	kafkago "github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/compress"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/insider/event-ingestion/config"
 )
 
+// Producer publishes event messages with one writer per topic, so that
+// topics are batched and flushed independently of each other.
 type Producer struct {
-	writer *kafkago.Writer
-	addr   string
+	cfg       config.KafkaConfig
+	topic     string
+	lateTopic string
+	routes    []Route
+
+	instanceID   string
+	partitionKey string
+	balancer     func() kafkago.Balancer
+	compression  compress.Compression
+	requiredAcks kafkago.RequiredAcks
+
+	transport *kafkago.Transport
+	dialer    *kafkago.Dialer
+	client    *kafkago.Client
+
+	mu      sync.Mutex
+	writers map[string]*kafkago.Writer
 }
 
 type EventMessage struct {
-	EventHash  uint64   `json:"event_hash"`
-	EventName  string   `json:"event_name"`
-	Channel    string   `json:"channel"`
-	CampaignID string   `json:"campaign_id"`
-	UserID     string   `json:"user_id"`
-	Timestamp  int64    `json:"timestamp"`
-	Tags       []string `json:"tags"`
-	Metadata   string   `json:"metadata"`
+	EventHash   uint64   `json:"event_hash"`
+	EventID     string   `json:"event_id"`
+	EventName   string   `json:"event_name"`
+	Channel     string   `json:"channel"`
+	CampaignID  string   `json:"campaign_id"`
+	UserID      string   `json:"user_id"`
+	SessionID   string   `json:"session_id"`
+	Timestamp   int64    `json:"timestamp"`
+	TimestampMs int64    `json:"timestamp_ms"`
+	Tags        []string `json:"tags"`
+	Metadata    string   `json:"metadata"`
+	SampleRate  float64  `json:"sample_rate"`
+
+	ReceivedAt   int64  `json:"received_at"`
+	ClientIP     string `json:"client_ip"`
+	DeviceType   string `json:"device_type"`
+	OS           string `json:"os"`
+	Browser      string `json:"browser"`
+	Country      string `json:"country"`
+	City         string `json:"city"`
+	CampaignName string `json:"campaign_name"`
+
+	// Late routes the message to the late-events topic when one is
+	// configured, and Tenant is matched by topic routes. None of them are
+	// part of the payload; Tenant, RequestID and TraceParent are sent as
+	// headers.
+	Late        bool   `json:"-"`
+	Tenant      string `json:"-"`
+	RequestID   string `json:"-"`
+	TraceParent string `json:"-"`
 }
 
 func NewProducer(cfg config.KafkaConfig) (*Producer, error) {
-	writer := &kafkago.Writer{
-		Addr:                   kafkago.TCP(cfg.Brokers...),
-		Topic:                  cfg.Topic,
-		Balancer:               &kafkago.LeastBytes{},
-		Compression:            compress.Lz4,
-		RequiredAcks:           kafkago.RequireAll,
-		AllowAutoTopicCreation: true,
+	var routes []Route
+	if cfg.RoutesFile != "" {
+		var err error
+		routes, err = LoadRoutes(cfg.RoutesFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateRoutes(routes, RoutedTopics(cfg)); err != nil {
+			return nil, err
+		}
+	}
+
+	balancer, err := newBalancer(cfg.PartitionKey, cfg.Balancer)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, ok := compressionCodecs[cfg.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kafka compression: %s", cfg.Compression)
+	}
+
+	acks, ok := requiredAcks[cfg.RequiredAcks]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kafka required_acks: %s", cfg.RequiredAcks)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, err := newSASLMechanism(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &kafkago.Transport{
+		DialTimeout: cfg.DialTimeout,
+		ClientID:    cfg.ClientID,
+		TLS:         tlsConfig,
+		SASL:        mechanism,
 	}
 
 	return &Producer{
-		writer: writer,
-		addr:   cfg.Brokers[0],
+		cfg:          cfg,
+		topic:        cfg.Topic,
+		lateTopic:    cfg.LateTopic,
+		routes:       routes,
+		instanceID:   instanceID(cfg.InstanceID),
+		partitionKey: cfg.PartitionKey,
+		balancer:     balancer,
+		compression:  compression,
+		requiredAcks: acks,
+		transport:    transport,
+		dialer: &kafkago.Dialer{
+			ClientID:      cfg.ClientID,
+			Timeout:       cfg.DialTimeout,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		client: &kafkago.Client{
+			Addr:      kafkago.TCP(cfg.Brokers...),
+			Timeout:   cfg.WriteTimeout,
+			Transport: transport,
+		},
+		writers: make(map[string]*kafkago.Writer),
 	}, nil
 }
 
 func (p *Producer) Publish(ctx context.Context, msg EventMessage) error {
-	data, err := json.Marshal(msg)
+	message, err := p.message(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal event message: %w", err)
+		return err
 	}
 
-	return p.writer.WriteMessages(ctx, kafkago.Message{
-		Key:   strconv.AppendUint(nil, msg.EventHash, 10),
-		Value: data,
-	})
+	writer, err := p.writer(ctx, p.route(&msg))
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteMessages(ctx, message)
 }
 
+// PublishBulk writes messages to their topics in parallel. Messages for
+// the same topic keep their relative order.
 func (p *Producer) PublishBulk(ctx context.Context, msgs []EventMessage) error {
-	messages := make([]kafkago.Message, len(msgs))
-	for i, msg := range msgs {
-		data, err := json.Marshal(msg)
+	byTopic := make(map[string][]kafkago.Message)
+	for _, msg := range msgs {
+		message, err := p.message(msg)
 		if err != nil {
-			return fmt.Errorf("failed to marshal event message: %w", err)
+			return err
 		}
-		messages[i] = kafkago.Message{
-			Key:   strconv.AppendUint(nil, msg.EventHash, 10),
-			Value: data,
+		topic := p.route(&msg)
+		byTopic[topic] = append(byTopic[topic], message)
+	}
+
+	// Every writer is created before any write starts, so that failing to
+	// create one never leaves writes running after PublishBulk returns.
+	writers := make(map[string]*kafkago.Writer, len(byTopic))
+	for topic := range byTopic {
+		writer, err := p.writer(ctx, topic)
+		if err != nil {
+			return err
 		}
+		writers[topic] = writer
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for topic, messages := range byTopic {
+		writer := writers[topic]
+		g.Go(func() error {
+			if err := writer.WriteMessages(ctx, messages...); err != nil {
+				return fmt.Errorf("failed to write to topic %s: %w", topic, err)
+			}
+			return nil
+		})
 	}
 
-	return p.writer.WriteMessages(ctx, messages...)
+	return g.Wait()
+}
+
+func (p *Producer) message(msg EventMessage) (kafkago.Message, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return kafkago.Message{}, fmt.Errorf("failed to marshal event message: %w", err)
+	}
+
+	return kafkago.Message{
+		Key:     messageKey(p.partitionKey, &msg),
+		Value:   data,
+		Headers: p.headers(&msg),
+	}, nil
+}
+
+// route picks the topic for msg: the late-events topic first, then the
+// first matching route, then the default topic.
+func (p *Producer) route(msg *EventMessage) string {
+	if msg.Late && p.lateTopic != "" {
+		return p.lateTopic
+	}
+	for i := range p.routes {
+		if p.routes[i].matches(msg) {
+			return p.routes[i].Topic
+		}
+	}
+	return p.topic
+}
+
+// writer returns the writer for topic, provisioning the topic and creating
+// the writer on first use.
+func (p *Producer) writer(ctx context.Context, topic string) (*kafkago.Writer, error) {
+	p.mu.Lock()
+	w, ok := p.writers[topic]
+	p.mu.Unlock()
+	if ok {
+		return w, nil
+	}
+
+	// Provisioning talks to the cluster, so it runs without the lock; a
+	// concurrent first use of the same topic just repeats it harmlessly.
+	if p.cfg.Topics.Provision {
+		if err := p.createTopics(ctx, topic); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if w, ok := p.writers[topic]; ok {
+		return w, nil
+	}
+
+	w = &kafkago.Writer{
+		Addr:         kafkago.TCP(p.cfg.Brokers...),
+		Topic:        topic,
+		Balancer:     p.balancer(),
+		Compression:  p.compression,
+		RequiredAcks: p.requiredAcks,
+		BatchSize:    p.cfg.BatchSize,
+		BatchBytes:   p.cfg.BatchBytes,
+		BatchTimeout: p.cfg.BatchTimeout,
+		WriteTimeout: p.cfg.WriteTimeout,
+		MaxAttempts:  p.cfg.MaxAttempts,
+		Transport:    p.transport,
+	}
+	p.writers[topic] = w
+	return w, nil
 }
 
 func (p *Producer) Close() error {
-	return p.writer.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for topic, w := range p.writers {
+		if err := w.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close writer for topic %s: %w", topic, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
+// Ping connects to every configured broker and reads the main topic's
+// partitions through it, so a single unreachable broker or a missing topic
+// fails the check.
 func (p *Producer) Ping(ctx context.Context) error {
-	conn, err := kafkago.Dial("tcp", p.addr)
+	var errs []error
+	for _, broker := range p.cfg.Brokers {
+		if err := p.pingBroker(ctx, broker); err != nil {
+			errs = append(errs, fmt.Errorf("broker %s: %w", broker, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Producer) pingBroker(ctx context.Context, broker string) error {
+	conn, err := p.dialer.DialContext(ctx, "tcp", broker)
 	if err != nil {
 		return fmt.Errorf("failed to connect to kafka: %w", err)
 	}
@@ -87,9 +298,12 @@ func (p *Producer) Ping(ctx context.Context) error {
 		conn.SetDeadline(deadline)
 	}
 
-	_, err = conn.Brokers()
+	partitions, err := conn.ReadPartitions(p.topic)
 	if err != nil {
-		return fmt.Errorf("failed to list brokers: %w", err)
+		return fmt.Errorf("failed to read metadata for topic %s: %w", p.topic, err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions", p.topic)
 	}
 
 	return nil
@@ -0,0 +1,85 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+// Route sends messages matching its event name, channel, tenant and tag,
+// each optional, to Topic. Tag matches messages carrying it among their tags.
+type Route struct {
+	EventName string `json:"event_name"`
+	Channel   string `json:"channel"`
+	Tenant    string `json:"tenant"`
+	Tag       string `json:"tag"`
+	Topic     string `json:"topic"`
+}
+
+type routesFile struct {
+	Routes []Route `json:"routes"`
+}
+
+// LoadRoutes reads topic routes from a JSON file of the form
+// {"routes": [{"event_name": "purchase", "topic": "events.transactional"}]}.
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kafka routes: %w", err)
+	}
+
+	var file routesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse kafka routes: %w", err)
+	}
+
+	for i, route := range file.Routes {
+		if route.Topic == "" {
+			return nil, fmt.Errorf("kafka route %d: topic is required", i)
+		}
+	}
+
+	return file.Routes, nil
+}
+
+// RoutedTopics returns the topics routes may name: the main topic and the
+// configured routed topics derived from it, which are the ones ClickHouse
+// has Kafka tables for. Events routed anywhere else would be published but
+// never stored.
+func RoutedTopics(cfg config.KafkaConfig) []string {
+	topics := []string{cfg.Topic}
+	for _, routed := range cfg.RoutedTopics {
+		topics = append(topics, cfg.Topic+"."+routed.Name)
+	}
+	return topics
+}
+
+// validateRoutes rejects routes to topics that nothing consumes.
+func validateRoutes(routes []Route, consumed []string) error {
+	for i, route := range routes {
+		if !slices.Contains(consumed, route.Topic) {
+			return fmt.Errorf("kafka route %d: topic %s is not consumed by ClickHouse, use one of %s", i, route.Topic, strings.Join(consumed, ", "))
+		}
+	}
+	return nil
+}
+
+func (r *Route) matches(msg *EventMessage) bool {
+	if r.EventName != "" && msg.EventName != r.EventName {
+		return false
+	}
+	if r.Channel != "" && msg.Channel != r.Channel {
+		return false
+	}
+	if r.Tenant != "" && msg.Tenant != r.Tenant {
+		return false
+	}
+	if r.Tag != "" && !slices.Contains(msg.Tags, r.Tag) {
+		return false
+	}
+	return true
+}
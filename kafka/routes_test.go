@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+func TestRoutedTopics(t *testing.T) {
+	cfg := config.KafkaConfig{
+		Topic:        "events",
+		RoutedTopics: []config.KafkaRoutedTopic{{Name: "transactional"}, {Name: "orders"}},
+	}
+	want := []string{"events", "events.transactional", "events.orders"}
+	if got := RoutedTopics(cfg); !slices.Equal(got, want) {
+		t.Errorf("RoutedTopics() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	consumed := RoutedTopics(config.KafkaConfig{
+		Topic:        "events",
+		RoutedTopics: []config.KafkaRoutedTopic{{Name: "transactional"}, {Name: "behavioral"}},
+	})
+
+	valid := []Route{
+		{EventName: "purchase", Topic: "events.transactional"},
+		{Channel: "web", Topic: "events.behavioral"},
+		{Tenant: "acme", Topic: "events"},
+		{Tag: "critical", Topic: "events.transactional"},
+	}
+	if err := validateRoutes(valid, consumed); err != nil {
+		t.Errorf("validateRoutes() error = %v", err)
+	}
+
+	for _, topic := range []string{"events.debug", "events.late", "orders"} {
+		if err := validateRoutes([]Route{{Topic: topic}}, consumed); err == nil {
+			t.Errorf("validateRoutes() accepted a route to %s", topic)
+		}
+	}
+}
+
+func TestProducerRoutesByTag(t *testing.T) {
+	p := &Producer{
+		topic: "events",
+		routes: []Route{
+			{EventName: "purchase", Tag: "critical", Topic: "events.transactional"},
+			{Tag: "bulk", Topic: "events.behavioral"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		msg  EventMessage
+		want string
+	}{
+		{"tagged purchase", EventMessage{EventName: "purchase", Tags: []string{"web", "critical"}}, "events.transactional"},
+		{"untagged purchase", EventMessage{EventName: "purchase", Tags: []string{"web"}}, "events"},
+		{"tag on another event", EventMessage{EventName: "view", Tags: []string{"critical"}}, "events"},
+		{"tag alone", EventMessage{EventName: "view", Tags: []string{"bulk"}}, "events.behavioral"},
+		{"no tags", EventMessage{EventName: "view"}, "events"},
+	}
+	for _, tt := range tests {
+		if got := p.route(&tt.msg); got != tt.want {
+			t.Errorf("%s: route() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+var compressionCodecs = map[string]compress.Compression{
+	"none":   0,
+	"gzip":   compress.Gzip,
+	"snappy": compress.Snappy,
+	"lz4":    compress.Lz4,
+	"zstd":   compress.Zstd,
+}
+
+var requiredAcks = map[string]kafkago.RequiredAcks{
+	"none": kafkago.RequireNone,
+	"one":  kafkago.RequireOne,
+	"all":  kafkago.RequireAll,
+}
+
+// newTLSConfig returns nil when TLS is disabled. A client certificate is
+// only loaded when both the certificate and key files are set.
+func newTLSConfig(cfg config.KafkaTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kafka CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in kafka CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("kafka TLS client auth requires both cert_file and key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kafka client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// newSASLMechanism returns nil when no mechanism is configured.
+func newSASLMechanism(cfg config.KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch cfg.Mechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return newSCRAM(scram.SHA256, cfg)
+	case "scram-sha-512":
+		return newSCRAM(scram.SHA512, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported kafka SASL mechanism: %s", cfg.Mechanism)
+	}
+}
+
+func newSCRAM(algo scram.Algorithm, cfg config.KafkaSASLConfig) (sasl.Mechanism, error) {
+	mechanism, err := scram.Mechanism(algo, cfg.Username, cfg.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up kafka SASL/SCRAM: %w", err)
+	}
+	return mechanism, nil
+}
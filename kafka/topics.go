@@ -0,0 +1,83 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// Provision prepares writers for every topic known from configuration: the
+// main and late-events topics and the route targets. With topic
+// provisioning enabled, missing topics are created first, so configuration
+// errors surface at startup rather than on the first event.
+func (p *Producer) Provision(ctx context.Context) error {
+	for _, topic := range p.Topics() {
+		if _, err := p.writer(ctx, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Topics returns the topics known from configuration, without duplicates.
+func (p *Producer) Topics() []string {
+	topics := []string{p.topic}
+	if p.lateTopic != "" {
+		topics = append(topics, p.lateTopic)
+	}
+	for _, route := range p.routes {
+		topics = append(topics, route.Topic)
+	}
+
+	slices.Sort(topics)
+	return slices.Compact(topics)
+}
+
+// CreateTopics creates the given topics, whether or not topic provisioning
+// is enabled. See createTopics.
+func (p *Producer) CreateTopics(ctx context.Context, topics ...string) error {
+	return p.createTopics(ctx, topics...)
+}
+
+// createTopics creates the topics that do not exist yet with the configured
+// partitions, replication factor and retention. Existing topics are not
+// changed.
+func (p *Producer) createTopics(ctx context.Context, topics ...string) error {
+	settings := p.cfg.Topics
+
+	var entries []kafkago.ConfigEntry
+	if settings.Retention > 0 {
+		entries = append(entries, kafkago.ConfigEntry{
+			ConfigName:  "retention.ms",
+			ConfigValue: strconv.FormatInt(settings.Retention.Milliseconds(), 10),
+		})
+	}
+
+	req := &kafkago.CreateTopicsRequest{
+		Topics: make([]kafkago.TopicConfig, len(topics)),
+	}
+	for i, topic := range topics {
+		req.Topics[i] = kafkago.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     settings.Partitions,
+			ReplicationFactor: settings.ReplicationFactor,
+			ConfigEntries:     entries,
+		}
+	}
+
+	resp, err := p.client.CreateTopics(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to create kafka topics: %w", err)
+	}
+
+	for topic, err := range resp.Errors {
+		if err != nil && !errors.Is(err, kafkago.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create kafka topic %s: %w", topic, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores metrics results by normalized query key. MemoryCache is the
+// default; a shared backend can be plugged in to share results across
+// instances.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]Metric, bool)
+	Set(ctx context.Context, key string, metrics []Metric, ttl time.Duration)
+}
+
+type cacheEntry struct {
+	metrics   []Metric
+	expiresAt time.Time
+}
+
+type MemoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	maxEntries int
+}
+
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		entries:    make(map[string]cacheEntry),
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) ([]Metric, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.metrics, true
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, metrics []Metric, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+
+	c.entries[key] = cacheEntry{
+		metrics:   metrics,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// evict drops expired entries, and if the cache is still full, one entry
+// that expires soonest. Callers must hold c.mu.
+func (c *MemoryCache) evict() {
+	now := time.Now()
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.expiresAt.Before(oldest) {
+			oldestKey = key
+			oldest = entry.expiresAt
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldestKey)
+	}
+}
@@ -1,34 +1,83 @@
 package metrics
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	service *Service
+	service  *Service
+	streamer *Streamer
 }
 
-func NewHandler(service *Service) *Handler {
+func NewHandler(service *Service, streamer *Streamer) *Handler {
 	return &Handler{
-		service: service,
+		service:  service,
+		streamer: streamer,
 	}
 }
 
 type MetricsQueryParams struct {
-	EventName string `form:"event_name" binding:"required"`
-	From      int64  `form:"from" binding:"omitempty"`
-	To        int64  `form:"to" binding:"omitempty"`
-	GroupBy   string `form:"group_by" binding:"omitempty,oneof=channel hour day"`
+	EventName   string `form:"event_name" binding:"required"`
+	From        int64  `form:"from" binding:"omitempty"`
+	To          int64  `form:"to" binding:"omitempty"`
+	GroupBy     string `form:"group_by" binding:"omitempty,oneof=channel hour day"`
+	Interval    string `form:"interval" binding:"omitempty,oneof=1m 5m 15m 1h 1d 1w 1M"`
+	TZ          string `form:"tz" binding:"omitempty"`
+	Compare     string `form:"compare" binding:"omitempty,oneof=previous_period previous_year custom"`
+	CompareFrom int64  `form:"compare_from" binding:"omitempty"`
+	CompareTo   int64  `form:"compare_to" binding:"omitempty"`
+	Accuracy    string `form:"accuracy" binding:"omitempty,oneof=approx exact hll"`
+	Dedup       *bool  `form:"dedup" binding:"omitempty"`
+	Scale       bool   `form:"scale" binding:"omitempty"`
+	LateSince   int64  `form:"late_since" binding:"omitempty"`
 }
 
-func (p *MetricsQueryParams) toMetricsQuery() MetricsQuery {
+var legacyGroupIntervals = map[string]string{
+	"hour": "1h",
+	"day":  "1d",
+}
+
+func (p *MetricsQueryParams) toMetricsQuery() (MetricsQuery, error) {
 	query := MetricsQuery{
 		EventName: p.EventName,
 		GroupBy:   p.GroupBy,
+		Interval:  p.Interval,
+		Location:  time.UTC,
+		Accuracy:  p.Accuracy,
+		Scale:     p.Scale,
+	}
+
+	if query.Accuracy == "" {
+		query.Accuracy = "approx"
+	}
+
+	if interval, ok := legacyGroupIntervals[p.GroupBy]; ok {
+		if p.Interval != "" && p.Interval != interval {
+			return MetricsQuery{}, fmt.Errorf("interval %s conflicts with group_by=%s", p.Interval, p.GroupBy)
+		}
+		query.Interval = interval
+	}
+
+	if p.GroupBy == "channel" && p.Interval != "" {
+		return MetricsQuery{}, fmt.Errorf("interval cannot be combined with group_by=channel")
+	}
+
+	if p.TZ != "" {
+		loc, err := time.LoadLocation(p.TZ)
+		if err != nil {
+			return MetricsQuery{}, fmt.Errorf("invalid tz: %s", p.TZ)
+		}
+		query.Location = loc
 	}
 
 	if p.From > 0 {
@@ -41,23 +90,126 @@ func (p *MetricsQueryParams) toMetricsQuery() MetricsQuery {
 		query.To = &t
 	}
 
-	return query
+	if p.LateSince > 0 {
+		t := time.Unix(p.LateSince, 0).UTC()
+		query.LateSince = &t
+	}
+
+	if p.Compare != "" {
+		if err := p.applyComparison(&query); err != nil {
+			return MetricsQuery{}, err
+		}
+	}
+
+	return query, nil
+}
+
+func (p *MetricsQueryParams) applyComparison(query *MetricsQuery) error {
+	query.Compare = p.Compare
+
+	// Buckets in the two windows are paired by their offset from each
+	// window's start, so the current window needs one even when the
+	// comparison window is given explicitly.
+	if query.From == nil || query.To == nil {
+		return fmt.Errorf("compare=%s requires from and to", p.Compare)
+	}
+	if query.From.After(*query.To) {
+		return fmt.Errorf("from must not be after to")
+	}
+
+	if p.Compare == "custom" {
+		if p.CompareFrom <= 0 || p.CompareTo <= 0 {
+			return fmt.Errorf("compare=custom requires compare_from and compare_to")
+		}
+		if p.CompareFrom > p.CompareTo {
+			return fmt.Errorf("compare_from must not be after compare_to")
+		}
+		from := time.Unix(p.CompareFrom, 0).UTC()
+		to := time.Unix(p.CompareTo, 0).UTC()
+		query.CompareFrom = &from
+		query.CompareTo = &to
+		return nil
+	}
+
+	var from, to time.Time
+	switch p.Compare {
+	case "previous_period":
+		shift := query.To.Sub(*query.From) + time.Second
+		from = query.From.Add(-shift)
+		to = query.To.Add(-shift)
+	case "previous_year":
+		from = query.From.AddDate(-1, 0, 0)
+		to = query.To.AddDate(-1, 0, 0)
+	}
+	query.CompareFrom = &from
+	query.CompareTo = &to
+
+	return nil
 }
 
 type MetricsResponse struct {
 	EventName   string           `json:"event_name"`
+	Accuracy    string           `json:"accuracy"`
+	Dedup       bool             `json:"dedup,omitempty"`
+	Scaled      bool             `json:"scaled,omitempty"`
 	From        int64            `json:"from,omitempty"`
 	To          int64            `json:"to,omitempty"`
 	TotalEvents *uint64          `json:"total_events,omitempty"`
 	UniqueUsers *uint64          `json:"unique_users,omitempty"`
 	GroupedBy   string           `json:"grouped_by,omitempty"`
+	Interval    string           `json:"interval,omitempty"`
+	Timezone    string           `json:"timezone,omitempty"`
+	Compare     string           `json:"compare,omitempty"`
+	CompareFrom int64            `json:"compare_from,omitempty"`
+	CompareTo   int64            `json:"compare_to,omitempty"`
+	Comparison  *Comparison      `json:"comparison,omitempty"`
+	LateSince   int64            `json:"late_since,omitempty"`
+	LateData    *bool            `json:"late_data,omitempty"`
+	LateEvents  *uint64          `json:"late_events,omitempty"`
 	Data        []MetricResponse `json:"data,omitempty"`
 }
 
 type MetricResponse struct {
-	Group       string `json:"group"`
-	TotalEvents uint64 `json:"total_events"`
-	UniqueUsers uint64 `json:"unique_users"`
+	Group       string      `json:"group"`
+	Timestamp   int64       `json:"timestamp,omitempty"`
+	TotalEvents uint64      `json:"total_events"`
+	UniqueUsers uint64      `json:"unique_users"`
+	Comparison  *Comparison `json:"comparison,omitempty"`
+}
+
+type Comparison struct {
+	TotalEvents         uint64   `json:"total_events"`
+	UniqueUsers         uint64   `json:"unique_users"`
+	TotalEventsDelta    int64    `json:"total_events_delta"`
+	TotalEventsDeltaPct *float64 `json:"total_events_delta_pct"`
+	UniqueUsersDelta    int64    `json:"unique_users_delta"`
+	UniqueUsersDeltaPct *float64 `json:"unique_users_delta_pct"`
+}
+
+func toComparison(current Metric) *Comparison {
+	if current.Previous == nil {
+		return nil
+	}
+
+	previous := current.Previous
+	return &Comparison{
+		TotalEvents:         previous.TotalEvents,
+		UniqueUsers:         previous.UniqueUsers,
+		TotalEventsDelta:    int64(current.TotalEvents) - int64(previous.TotalEvents),
+		TotalEventsDeltaPct: deltaPct(current.TotalEvents, previous.TotalEvents),
+		UniqueUsersDelta:    int64(current.UniqueUsers) - int64(previous.UniqueUsers),
+		UniqueUsersDeltaPct: deltaPct(current.UniqueUsers, previous.UniqueUsers),
+	}
+}
+
+// deltaPct returns nil when there is no previous value to compare against,
+// since the percentage change from zero is undefined.
+func deltaPct(current, previous uint64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	pct := (float64(current) - float64(previous)) / float64(previous) * 100
+	return &pct
 }
 
 type ErrorResponse struct {
@@ -67,6 +219,9 @@ type ErrorResponse struct {
 func toMetricsResponse(query MetricsQuery, metrics []Metric) MetricsResponse {
 	resp := MetricsResponse{
 		EventName: query.EventName,
+		Accuracy:  query.Accuracy,
+		Dedup:     query.Dedup,
+		Scaled:    query.Scale,
 	}
 
 	if query.From != nil {
@@ -75,23 +230,39 @@ func toMetricsResponse(query MetricsQuery, metrics []Metric) MetricsResponse {
 	if query.To != nil {
 		resp.To = query.To.Unix()
 	}
+	if query.Compare != "" {
+		resp.Compare = query.Compare
+		resp.CompareFrom = query.CompareFrom.Unix()
+		resp.CompareTo = query.CompareTo.Unix()
+	}
 
-	if query.GroupBy == "" {
+	if query.GroupBy == "" && query.Interval == "" {
 		var total, unique uint64
 		if len(metrics) > 0 {
 			total = metrics[0].TotalEvents
 			unique = metrics[0].UniqueUsers
+			resp.Comparison = toComparison(metrics[0])
 		}
 		resp.TotalEvents = &total
 		resp.UniqueUsers = &unique
 	} else {
 		resp.GroupedBy = query.GroupBy
+		if query.Interval != "" {
+			resp.Interval = query.Interval
+			resp.Timezone = query.Location.String()
+		}
 		resp.Data = make([]MetricResponse, len(metrics))
 		for i, m := range metrics {
 			resp.Data[i] = MetricResponse{
 				Group:       m.Group,
 				TotalEvents: m.TotalEvents,
 				UniqueUsers: m.UniqueUsers,
+				Comparison:  toComparison(m),
+			}
+			if query.Interval != "" {
+				bucket := m.Bucket.In(query.Location)
+				resp.Data[i].Group = bucket.Format(time.RFC3339)
+				resp.Data[i].Timestamp = bucket.Unix()
 			}
 		}
 	}
@@ -109,9 +280,22 @@ func (h *Handler) GetMetrics(c *gin.Context) {
 		return
 	}
 
-	query := params.toMetricsQuery()
+	query, err := params.toMetricsQuery()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	query = h.service.ResolveDedup(query, params.Dedup)
 
 	metrics, err := h.service.GetMetrics(c.Request.Context(), query)
+	if errors.Is(err, ErrExactRangeTooLarge) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("failed to fetch metrics: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -120,9 +304,107 @@ func (h *Handler) GetMetrics(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, toMetricsResponse(query, metrics))
+	resp := toMetricsResponse(query, metrics)
+
+	if query.LateSince != nil {
+		late, err := h.service.LateEvents(c.Request.Context(), query)
+		if err != nil {
+			log.Printf("failed to count late events: %v", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "failed to fetch metrics",
+			})
+			return
+		}
+		lateData := late > 0
+		resp.LateSince = query.LateSince.Unix()
+		resp.LateData = &lateData
+		resp.LateEvents = &late
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("failed to marshal metrics response: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "failed to fetch metrics",
+		})
+		return
+	}
+
+	etag := `"` + strconv.FormatUint(xxhash.Sum64(body), 16) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(h.service.CacheTTL(query).Seconds())))
+
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+func (h *Handler) GetMetricsStream(c *gin.Context) {
+	var params MetricsQueryParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// Streams share feeds by query, and late counts are relative to each
+	// caller's own watermark, so they are only offered by GET /metrics.
+	if params.LateSince != 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "late_since is not supported on streams",
+		})
+		return
+	}
+
+	query, err := params.toMetricsQuery()
+	if err == nil {
+		query = h.service.ResolveDedup(query, params.Dedup)
+		err = h.service.checkExactRange(query)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	updates, unsubscribe, err := h.streamer.Subscribe(query)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	defer unsubscribe()
+
+	// Streams outlive the server's write timeout, so lift it for this request.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("failed to clear write deadline for metrics stream: %v", err)
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case resp, ok := <-updates:
+			if !ok {
+				return false
+			}
+			c.SSEvent("metrics", resp)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
 }
 
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	r.GET("/metrics", h.GetMetrics)
+	r.GET("/metrics/stream", h.GetMetricsStream)
 }
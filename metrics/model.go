@@ -7,10 +7,23 @@ type MetricsQuery struct {
 	From      *time.Time
 	To        *time.Time
 	GroupBy   string
+	Interval  string
+	Location  *time.Location
+	Accuracy  string
+	Dedup     bool
+	Scale     bool
+
+	LateSince *time.Time
+
+	Compare     string
+	CompareFrom *time.Time
+	CompareTo   *time.Time
 }
 
 type Metric struct {
 	Group       string
+	Bucket      time.Time
 	TotalEvents uint64
 	UniqueUsers uint64
+	Previous    *Metric
 }
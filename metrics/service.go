@@ -2,26 +2,128 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
 	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
 )
 
+var ErrExactRangeTooLarge = errors.New("exact counts are limited to bounded time ranges")
+
 type metricsRepository interface {
-	GetMetrics(ctx context.Context, eventName string, startTime, endTime *time.Time, groupBy string) ([]repository.MetricRow, error)
+	GetMetrics(ctx context.Context, filter repository.MetricsFilter) ([]repository.MetricRow, error)
+	CountReceivedSince(ctx context.Context, filter repository.MetricsFilter, watermark time.Time) (uint64, error)
 }
 
 type Service struct {
-	repo metricsRepository
+	repo          metricsRepository
+	maxExactRange time.Duration
+
+	cache         Cache
+	group         singleflight.Group
+	liveTTL       time.Duration
+	historicalTTL time.Duration
+	settleWindow  time.Duration
 }
 
-func NewService(repo metricsRepository) *Service {
-	return &Service{repo: repo}
+// NewService creates a metrics service. A nil cache disables result caching.
+func NewService(repo metricsRepository, cfg config.MetricsConfig, cache Cache) *Service {
+	return &Service{
+		repo:          repo,
+		maxExactRange: cfg.MaxExactRange,
+		cache:         cache,
+		liveTTL:       cfg.CacheLiveTTL,
+		historicalTTL: cfg.CacheHistoricalTTL,
+		settleWindow:  cfg.CacheSettleWindow,
+	}
 }
 
 func (s *Service) GetMetrics(ctx context.Context, query MetricsQuery) ([]Metric, error) {
-	rows, err := s.repo.GetMetrics(ctx, query.EventName, query.From, query.To, query.GroupBy)
+	if err := s.checkExactRange(query); err != nil {
+		return nil, err
+	}
+
+	if s.cache == nil {
+		return s.query(ctx, query)
+	}
+
+	key := cacheKey(query)
+	if metrics, ok := s.cache.Get(ctx, key); ok {
+		return metrics, nil
+	}
+
+	// Concurrent identical queries share a single ClickHouse round trip. The
+	// shared query must not be cancelled just because the caller that happened
+	// to start it went away.
+	result, err, _ := s.group.Do(key, func() (any, error) {
+		metrics, err := s.query(context.WithoutCancel(ctx), query)
+		if err != nil {
+			return nil, err
+		}
+		if ttl := s.CacheTTL(query); ttl > 0 {
+			s.cache.Set(ctx, key, metrics, ttl)
+		}
+		return metrics, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]Metric), nil
+}
+
+// CacheTTL returns how long results for query may be reused. Ranges that
+// end within the settle window of now can still receive events and get the
+// short live TTL; fully historical ranges get the long one.
+func (s *Service) CacheTTL(query MetricsQuery) time.Duration {
+	if query.To == nil || query.To.After(time.Now().Add(-s.settleWindow)) {
+		return s.liveTTL
+	}
+	return s.historicalTTL
+}
+
+func (s *Service) query(ctx context.Context, query MetricsQuery) ([]Metric, error) {
+	metrics, err := s.fetch(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Compare == "" {
+		return metrics, nil
+	}
+
+	comparisonQuery := query
+	comparisonQuery.From = query.CompareFrom
+	comparisonQuery.To = query.CompareTo
+	comparisonQuery.Compare = ""
+
+	previous, err := s.fetch(ctx, comparisonQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comparison metrics: %w", err)
+	}
+
+	return attachPrevious(query, metrics, previous), nil
+}
+
+// LateEvents counts events in the query's range received since the
+// query's LateSince watermark. It is not cached, since it exists to detect
+// changes that a cached result would hide.
+func (s *Service) LateEvents(ctx context.Context, query MetricsQuery) (uint64, error) {
+	count, err := s.repo.CountReceivedSince(ctx, toFilter(query), *query.LateSince)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count late events: %w", err)
+	}
+	return count, nil
+}
+
+func (s *Service) fetch(ctx context.Context, query MetricsQuery) ([]Metric, error) {
+	rows, err := s.repo.GetMetrics(ctx, toFilter(query))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get metrics: %w", err)
 	}
@@ -30,6 +132,7 @@ func (s *Service) GetMetrics(ctx context.Context, query MetricsQuery) ([]Metric,
 	for i, row := range rows {
 		metrics[i] = Metric{
 			Group:       row.GroupKey,
+			Bucket:      row.Bucket,
 			TotalEvents: row.TotalCount,
 			UniqueUsers: row.UniqueUsers,
 		}
@@ -37,3 +140,185 @@ func (s *Service) GetMetrics(ctx context.Context, query MetricsQuery) ([]Metric,
 
 	return metrics, nil
 }
+
+func toFilter(query MetricsQuery) repository.MetricsFilter {
+	filter := repository.MetricsFilter{
+		EventName: query.EventName,
+		StartTime: query.From,
+		EndTime:   query.To,
+		GroupBy:   query.GroupBy,
+		Interval:  query.Interval,
+		Accuracy:  query.Accuracy,
+		Dedup:     query.Dedup,
+		Scale:     query.Scale,
+	}
+	if query.Location != nil {
+		filter.Timezone = query.Location.String()
+	}
+	return filter
+}
+
+func cacheKey(query MetricsQuery) string {
+	location := "UTC"
+	if query.Location != nil {
+		location = query.Location.String()
+	}
+
+	parts := []string{
+		query.EventName,
+		unixOrEmpty(query.From),
+		unixOrEmpty(query.To),
+		query.GroupBy,
+		query.Interval,
+		location,
+		query.Accuracy,
+		strconv.FormatBool(query.Dedup),
+		strconv.FormatBool(query.Scale),
+		query.Compare,
+		unixOrEmpty(query.CompareFrom),
+		unixOrEmpty(query.CompareTo),
+	}
+	return strings.Join(parts, "|")
+}
+
+func unixOrEmpty(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// ResolveDedup sets whether query counts distinct events rather than stored
+// rows. Counts are of stored rows unless the caller asks otherwise, so that
+// they mean the same whichever range is queried and can be read from the
+// rollups, which count every stored row. Deduplicating reads raw events and
+// is bounded like exact counting.
+func (s *Service) ResolveDedup(query MetricsQuery, requested *bool) MetricsQuery {
+	query.Dedup = requested != nil && *requested
+	return query
+}
+
+// checkExactRange guards exact unique counting and deduplicated totals,
+// whose memory use grows with the number of distinct values scanned, against
+// open-ended or very long ranges.
+func (s *Service) checkExactRange(query MetricsQuery) error {
+	if (query.Accuracy != "exact" && !query.Dedup) || s.maxExactRange <= 0 {
+		return nil
+	}
+
+	if query.From == nil || query.To == nil {
+		return fmt.Errorf("%w: from and to are required", ErrExactRangeTooLarge)
+	}
+	if query.To.Sub(*query.From) > s.maxExactRange {
+		return fmt.Errorf("%w: range must not exceed %s", ErrExactRangeTooLarge, s.maxExactRange)
+	}
+	if query.Compare != "" && query.CompareTo.Sub(*query.CompareFrom) > s.maxExactRange {
+		return fmt.Errorf("%w: comparison range must not exceed %s", ErrExactRangeTooLarge, s.maxExactRange)
+	}
+
+	return nil
+}
+
+// attachPrevious pairs each metric with its counterpart from the comparison
+// window. Time buckets are matched by their offset from the start of their
+// window, since the two windows cover different absolute times and may hold
+// a different number of buckets; channels are matched by name, and channels
+// that only appear in the comparison window are kept with zero current
+// values.
+func attachPrevious(query MetricsQuery, current, previous []Metric) []Metric {
+	if query.Interval != "" {
+		byOffset := make(map[int]Metric, len(previous))
+		for _, m := range previous {
+			byOffset[bucketOffset(query.Interval, query.Location, *query.CompareFrom, m.Bucket)] = m
+		}
+		for i := range current {
+			prev := byOffset[bucketOffset(query.Interval, query.Location, *query.From, current[i].Bucket)]
+			current[i].Previous = &prev
+		}
+		return current
+	}
+
+	if query.GroupBy != "channel" {
+		for i := range current {
+			prev := Metric{}
+			if i < len(previous) {
+				prev = previous[i]
+			}
+			current[i].Previous = &prev
+		}
+		return current
+	}
+
+	byGroup := make(map[string]Metric, len(previous))
+	for _, m := range previous {
+		byGroup[m.Group] = m
+	}
+
+	for i := range current {
+		prev := byGroup[current[i].Group]
+		current[i].Previous = &prev
+		delete(byGroup, current[i].Group)
+	}
+
+	for _, m := range previous {
+		if _, ok := byGroup[m.Group]; !ok {
+			continue
+		}
+		prev := m
+		current = append(current, Metric{Group: m.Group, Previous: &prev})
+	}
+
+	return current
+}
+
+// bucketOffset returns how many interval buckets bucket comes after the
+// bucket holding start. Days, weeks and months are counted on the calendar
+// in loc, so that months of different lengths and days that change offset
+// still line up.
+func bucketOffset(interval string, loc *time.Location, start, bucket time.Time) int {
+	if loc == nil {
+		loc = time.UTC
+	}
+	start = start.In(loc)
+	bucket = bucket.In(loc)
+
+	switch interval {
+	case "1M":
+		return (bucket.Year()-start.Year())*12 + int(bucket.Month()) - int(start.Month())
+	case "1w":
+		// Weeks start on Monday, as toMonday buckets them.
+		monday := calendarDays(start) - (int(start.Weekday())+6)%7
+		return floorDiv(calendarDays(bucket)-monday, 7)
+	case "1d":
+		return calendarDays(bucket) - calendarDays(start)
+	}
+
+	step, ok := intervalSteps[interval]
+	if !ok {
+		return 0
+	}
+	// Sub-day intervals divide a day evenly, so buckets are aligned to
+	// local midnight.
+	midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	return floorDiv(int(bucket.Sub(midnight)/time.Minute), int(step/time.Minute)) - int(start.Sub(midnight)/step)
+}
+
+var intervalSteps = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+}
+
+// calendarDays returns the number of days from the Unix epoch to t's date.
+func calendarDays(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
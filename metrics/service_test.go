@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketOffset(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		interval string
+		loc      *time.Location
+		start    time.Time
+		bucket   time.Time
+		want     int
+	}{
+		{"first hour", "1h", time.UTC, date(2024, 3, 1, 10, 30), date(2024, 3, 1, 10, 0), 0},
+		{"later hour", "1h", time.UTC, date(2024, 3, 1, 10, 30), date(2024, 3, 1, 13, 0), 3},
+		{"next day 15m", "15m", time.UTC, date(2024, 3, 1, 23, 50), date(2024, 3, 2, 0, 15), 2},
+		{"day", "1d", time.UTC, date(2024, 3, 1, 12, 0), date(2024, 3, 4, 0, 0), 3},
+		{"day in zone", "1d", berlin, date(2024, 3, 1, 23, 30), time.Date(2024, 3, 3, 0, 0, 0, 0, berlin), 1},
+		{"week from mid-week", "1w", time.UTC, date(2024, 3, 6, 0, 0), date(2024, 3, 11, 0, 0), 1},
+		{"week start", "1w", time.UTC, date(2024, 3, 6, 0, 0), date(2024, 3, 4, 0, 0), 0},
+		{"month across year", "1M", time.UTC, date(2023, 11, 15, 0, 0), date(2024, 2, 1, 0, 0), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bucketOffset(tt.interval, tt.loc, tt.start, tt.bucket); got != tt.want {
+				t.Errorf("bucketOffset() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachPreviousMatchesByOffset(t *testing.T) {
+	from := date(2024, 3, 1, 0, 0)
+	to := date(2024, 3, 3, 23, 59)
+	compareFrom := date(2024, 2, 1, 0, 0)
+	compareTo := date(2024, 2, 3, 23, 59)
+	query := MetricsQuery{
+		From: &from, To: &to,
+		Interval: "1d", Location: time.UTC,
+		CompareFrom: &compareFrom, CompareTo: &compareTo,
+	}
+
+	current := []Metric{
+		{Bucket: date(2024, 3, 1, 0, 0), TotalEvents: 1},
+		{Bucket: date(2024, 3, 2, 0, 0), TotalEvents: 2},
+		{Bucket: date(2024, 3, 3, 0, 0), TotalEvents: 3},
+	}
+	// The comparison window has a gap on its first day.
+	previous := []Metric{
+		{Bucket: date(2024, 2, 2, 0, 0), TotalEvents: 20},
+		{Bucket: date(2024, 2, 3, 0, 0), TotalEvents: 30},
+	}
+
+	got := attachPrevious(query, current, previous)
+	want := []uint64{0, 20, 30}
+	for i, m := range got {
+		if m.Previous == nil {
+			t.Fatalf("bucket %d has no previous value", i)
+		}
+		if m.Previous.TotalEvents != want[i] {
+			t.Errorf("bucket %d previous = %d, want %d", i, m.Previous.TotalEvents, want[i])
+		}
+	}
+}
+
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestResolveDedup(t *testing.T) {
+	service := &Service{maxExactRange: 31 * 24 * time.Hour}
+	from := date(2024, 3, 1, 0, 0)
+	week := from.Add(7 * 24 * time.Hour)
+	yes, no := true, false
+
+	tests := []struct {
+		name      string
+		query     MetricsQuery
+		requested *bool
+		want      bool
+	}{
+		{"bounded range", MetricsQuery{From: &from, To: &week}, nil, false},
+		{"open range", MetricsQuery{From: &from}, nil, false},
+		{"turned on", MetricsQuery{From: &from, To: &week}, &yes, true},
+		{"turned off", MetricsQuery{From: &from, To: &week}, &no, false},
+	}
+	for _, tt := range tests {
+		if got := service.ResolveDedup(tt.query, tt.requested).Dedup; got != tt.want {
+			t.Errorf("%s: Dedup = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
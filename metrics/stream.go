@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/insider/event-ingestion/config"
+)
+
+// ErrTooManyFeeds is returned when a new feed would exceed the configured
+// number of concurrent feeds.
+var ErrTooManyFeeds = errors.New("too many concurrent metrics streams")
+
+// Streamer fans out periodically refreshed metrics to streaming clients.
+// Subscribers of the same normalized query share one feed, so N viewers of a
+// dashboard cost a single ClickHouse poll per interval. Every feed polls
+// ClickHouse, so the number of distinct queries streamed at once is capped.
+type Streamer struct {
+	service  *Service
+	interval time.Duration
+	maxFeeds int
+
+	mu     sync.Mutex
+	feeds  map[string]*feed
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type feed struct {
+	query       MetricsQuery
+	subscribers map[chan MetricsResponse]struct{}
+	latest      *MetricsResponse
+	cancel      context.CancelFunc
+}
+
+func NewStreamer(service *Service, cfg config.MetricsConfig) *Streamer {
+	return &Streamer{
+		service:  service,
+		interval: cfg.StreamInterval,
+		maxFeeds: cfg.StreamMaxFeeds,
+		feeds:    make(map[string]*feed),
+	}
+}
+
+// Subscribe returns a channel receiving a response whenever the metrics for
+// query change, starting with the latest known value. The channel is closed
+// when the streamer shuts down; callers must invoke the returned function
+// once they stop reading. Joining an existing feed always succeeds, while
+// starting a new one fails with ErrTooManyFeeds at the cap.
+func (s *Streamer) Subscribe(query MetricsQuery) (<-chan MetricsResponse, func(), error) {
+	ch := make(chan MetricsResponse, 1)
+	key := cacheKey(query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		close(ch)
+		return ch, func() {}, nil
+	}
+
+	f, ok := s.feeds[key]
+	if !ok {
+		if s.maxFeeds > 0 && len(s.feeds) >= s.maxFeeds {
+			return nil, nil, ErrTooManyFeeds
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		f = &feed{
+			query:       query,
+			subscribers: make(map[chan MetricsResponse]struct{}),
+			cancel:      cancel,
+		}
+		s.feeds[key] = f
+		s.wg.Add(1)
+		go s.poll(ctx, key, f)
+	}
+
+	f.subscribers[ch] = struct{}{}
+	if f.latest != nil {
+		ch <- *f.latest
+	}
+
+	return ch, func() { s.unsubscribe(key, ch) }, nil
+}
+
+func (s *Streamer) unsubscribe(key string, ch chan MetricsResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.feeds[key]
+	if !ok {
+		return
+	}
+	if _, ok := f.subscribers[ch]; !ok {
+		return
+	}
+
+	delete(f.subscribers, ch)
+	close(ch)
+
+	if len(f.subscribers) == 0 {
+		f.cancel()
+		delete(s.feeds, key)
+	}
+}
+
+// Close stops every feed and closes all subscriber channels so that open
+// streams end during server shutdown, then waits for the feeds' polls to
+// return.
+func (s *Streamer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for key, f := range s.feeds {
+		f.cancel()
+		for ch := range f.subscribers {
+			close(ch)
+		}
+		delete(s.feeds, key)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Streamer) poll(ctx context.Context, key string, f *feed) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		metrics, err := s.service.GetMetrics(ctx, f.query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("failed to refresh streamed metrics: %v", err)
+		} else {
+			resp := toMetricsResponse(f.query, metrics)
+			body, err := json.Marshal(resp)
+			if err == nil && !bytes.Equal(body, last) {
+				last = body
+				s.broadcast(key, f, resp)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// broadcast delivers resp to every subscriber, replacing any update a slow
+// subscriber has not read yet so it always sees the most recent value.
+func (s *Streamer) broadcast(key string, f *feed, resp MetricsResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.feeds[key] != f {
+		return
+	}
+
+	f.latest = &resp
+	for ch := range f.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- resp
+	}
+}
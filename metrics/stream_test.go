@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+type fakeMetricsRepository struct{}
+
+func (fakeMetricsRepository) GetMetrics(context.Context, repository.MetricsFilter) ([]repository.MetricRow, error) {
+	return nil, nil
+}
+
+func (fakeMetricsRepository) CountReceivedSince(context.Context, repository.MetricsFilter, time.Time) (uint64, error) {
+	return 0, nil
+}
+
+func TestStreamerCapsFeeds(t *testing.T) {
+	cfg := config.MetricsConfig{StreamInterval: time.Hour, StreamMaxFeeds: 1}
+	streamer := NewStreamer(NewService(fakeMetricsRepository{}, cfg, nil), cfg)
+	defer streamer.Close()
+
+	purchases := MetricsQuery{EventName: "purchase"}
+	views := MetricsQuery{EventName: "product_view"}
+
+	_, first, err := streamer.Subscribe(purchases)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	_, second, err := streamer.Subscribe(purchases)
+	if err != nil {
+		t.Fatalf("joining a feed: Subscribe() error = %v", err)
+	}
+	if _, _, err := streamer.Subscribe(views); !errors.Is(err, ErrTooManyFeeds) {
+		t.Fatalf("Subscribe() error = %v, want ErrTooManyFeeds", err)
+	}
+
+	// The feed ends with its last subscriber, making room for another.
+	first()
+	second()
+	_, unsubscribe, err := streamer.Subscribe(views)
+	if err != nil {
+		t.Fatalf("Subscribe() after the feed ended: error = %v", err)
+	}
+	unsubscribe()
+}
+
+// blockingMetricsRepository holds every query until it is cancelled.
+type blockingMetricsRepository struct {
+	started  chan struct{}
+	returned atomic.Bool
+}
+
+func (r *blockingMetricsRepository) GetMetrics(ctx context.Context, _ repository.MetricsFilter) ([]repository.MetricRow, error) {
+	close(r.started)
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	r.returned.Store(true)
+	return nil, ctx.Err()
+}
+
+func (r *blockingMetricsRepository) CountReceivedSince(context.Context, repository.MetricsFilter, time.Time) (uint64, error) {
+	return 0, nil
+}
+
+func TestStreamerCloseWaitsForPolls(t *testing.T) {
+	repo := &blockingMetricsRepository{started: make(chan struct{})}
+	cfg := config.MetricsConfig{StreamInterval: time.Hour}
+	streamer := NewStreamer(NewService(repo, cfg, nil), cfg)
+
+	updates, _, err := streamer.Subscribe(MetricsQuery{EventName: "purchase"})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	<-repo.started
+
+	streamer.Close()
+	if !repo.returned.Load() {
+		t.Error("Close() returned while a poll was still querying")
+	}
+	if _, ok := <-updates; ok {
+		t.Error("subscriber channel still open after Close()")
+	}
+}
@@ -0,0 +1,166 @@
+package retention
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type PolicyParams struct {
+	EventName string `uri:"event_name" binding:"required"`
+}
+
+type PolicyRequest struct {
+	TTLDays *uint32 `json:"ttl_days" binding:"required"`
+}
+
+type PolicyResponse struct {
+	EventName string `json:"event_name"`
+	TTLDays   uint32 `json:"ttl_days"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type PartitionResponse struct {
+	Partition   string `json:"partition"`
+	Rows        uint64 `json:"rows"`
+	BytesOnDisk uint64 `json:"bytes_on_disk"`
+	Disk        string `json:"disk"`
+	MinTime     int64  `json:"min_time"`
+	MaxTime     int64  `json:"max_time"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) ListPolicies(c *gin.Context) {
+	policies, err := h.service.ListPolicies(c.Request.Context())
+	if err != nil {
+		log.Printf("failed to list retention policies: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := make([]PolicyResponse, len(policies))
+	for i, p := range policies {
+		resp[i] = PolicyResponse{
+			EventName: p.EventName,
+			TTLDays:   p.TTLDays,
+			UpdatedAt: p.UpdatedAt.Unix(),
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) PutPolicy(c *gin.Context) {
+	var params PolicyParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	var req PolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.SetPolicy(c.Request.Context(), params.EventName, *req.TTLDays); err != nil {
+		log.Printf("failed to set retention policy: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) DeletePolicy(c *gin.Context) {
+	var params PolicyParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.DeletePolicy(c.Request.Context(), params.EventName); err != nil {
+		log.Printf("failed to delete retention policy: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ApplyPolicies applies the stored policies and configured defaults to the
+// events table, e.g. after the defaults were changed in config.
+func (h *Handler) ApplyPolicies(c *gin.Context) {
+	if err := h.service.Apply(c.Request.Context()); err != nil {
+		log.Printf("failed to apply retention: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListPartitions(c *gin.Context) {
+	partitions, err := h.service.ListPartitions(c.Request.Context())
+	if err != nil {
+		log.Printf("failed to list partitions: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := make([]PartitionResponse, len(partitions))
+	for i, p := range partitions {
+		resp[i] = PartitionResponse{
+			Partition:   p.Partition,
+			Rows:        p.Rows,
+			BytesOnDisk: p.BytesOnDisk,
+			Disk:        p.Disk,
+			MinTime:     p.MinTime.Unix(),
+			MaxTime:     p.MaxTime.Unix(),
+		}
+		if !p.ExpiresAt.IsZero() && p.ExpiresAt.Unix() > 0 {
+			resp[i].ExpiresAt = p.ExpiresAt.Unix()
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) RegisterRoutes(r gin.IRoutes) {
+	r.GET("/admin/retention", h.ListPolicies)
+	r.PUT("/admin/retention/:event_name", h.PutPolicy)
+	r.DELETE("/admin/retention/:event_name", h.DeletePolicy)
+	r.POST("/admin/retention/apply", h.ApplyPolicies)
+	r.GET("/admin/retention/partitions", h.ListPartitions)
+}
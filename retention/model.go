@@ -0,0 +1,19 @@
+package retention
+
+import "time"
+
+type Policy struct {
+	EventName string
+	TTLDays   uint32
+	UpdatedAt time.Time
+}
+
+type Partition struct {
+	Partition   string
+	Rows        uint64
+	BytesOnDisk uint64
+	Disk        string
+	MinTime     time.Time
+	MaxTime     time.Time
+	ExpiresAt   time.Time
+}
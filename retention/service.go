@@ -0,0 +1,98 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+type retentionRepository interface {
+	ListPolicies(ctx context.Context) ([]repository.RetentionPolicyRow, error)
+	SavePolicy(ctx context.Context, eventName string, ttlDays uint32) error
+	DeletePolicy(ctx context.Context, eventName string) error
+	ApplyTTL(ctx context.Context, rules repository.TTLRules) error
+	ListPartitions(ctx context.Context) ([]repository.PartitionRow, error)
+}
+
+type Service struct {
+	repo retentionRepository
+	cfg  config.RetentionConfig
+}
+
+func NewService(repo retentionRepository, cfg config.RetentionConfig) *Service {
+	return &Service{
+		repo: repo,
+		cfg:  cfg,
+	}
+}
+
+func (s *Service) ListPolicies(ctx context.Context) ([]Policy, error) {
+	rows, err := s.repo.ListPolicies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+
+	policies := make([]Policy, len(rows))
+	for i, row := range rows {
+		policies[i] = Policy(row)
+	}
+	return policies, nil
+}
+
+// SetPolicy stores the retention of an event name and reapplies the table
+// TTL. A ttlDays of zero keeps that event name forever, overriding the
+// default retention.
+func (s *Service) SetPolicy(ctx context.Context, eventName string, ttlDays uint32) error {
+	if err := s.repo.SavePolicy(ctx, eventName, ttlDays); err != nil {
+		return fmt.Errorf("failed to save retention policy: %w", err)
+	}
+	return s.Apply(ctx)
+}
+
+func (s *Service) DeletePolicy(ctx context.Context, eventName string) error {
+	if err := s.repo.DeletePolicy(ctx, eventName); err != nil {
+		return fmt.Errorf("failed to delete retention policy: %w", err)
+	}
+	return s.Apply(ctx)
+}
+
+// Apply rebuilds the events table TTL from the stored policies and the
+// configured defaults, leaving the table alone if its TTL already matches.
+// It runs after migrations and from the admin API rather than on every
+// start, since changing the TTL makes ClickHouse rewrite existing parts.
+func (s *Service) Apply(ctx context.Context) error {
+	rows, err := s.repo.ListPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list retention policies: %w", err)
+	}
+
+	rules := repository.TTLRules{
+		PerEvent:      make(map[string]uint32, len(rows)),
+		DefaultDays:   s.cfg.DefaultDays,
+		ColdVolume:    s.cfg.ColdVolume,
+		ColdAfterDays: s.cfg.ColdAfterDays,
+	}
+	for _, row := range rows {
+		rules.PerEvent[row.EventName] = row.TTLDays
+	}
+
+	if err := s.repo.ApplyTTL(ctx, rules); err != nil {
+		return fmt.Errorf("failed to apply retention: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) ListPartitions(ctx context.Context) ([]Partition, error) {
+	rows, err := s.repo.ListPartitions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	partitions := make([]Partition, len(rows))
+	for i, row := range rows {
+		partitions[i] = Partition(row)
+	}
+	return partitions, nil
+}
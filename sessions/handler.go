@@ -0,0 +1,85 @@
+package sessions
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type MetricsQueryParams struct {
+	From    int64  `form:"from" binding:"omitempty"`
+	To      int64  `form:"to" binding:"omitempty"`
+	Channel string `form:"channel" binding:"omitempty,oneof=web mobile api email push"`
+}
+
+type MetricsResponse struct {
+	From                int64   `json:"from,omitempty"`
+	To                  int64   `json:"to,omitempty"`
+	Channel             string  `json:"channel,omitempty"`
+	Sessions            uint64  `json:"sessions"`
+	Bounces             uint64  `json:"bounces"`
+	BounceRate          float64 `json:"bounce_rate"`
+	AvgDurationSeconds  float64 `json:"avg_duration_seconds"`
+	AvgEventsPerSession float64 `json:"avg_events_per_session"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) GetMetrics(c *gin.Context) {
+	var params MetricsQueryParams
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	query := MetricsQuery{Channel: params.Channel}
+	if params.From > 0 {
+		t := time.Unix(params.From, 0).UTC()
+		query.From = &t
+	}
+	if params.To > 0 {
+		t := time.Unix(params.To, 0).UTC()
+		query.To = &t
+	}
+
+	metrics, err := h.service.GetMetrics(c.Request.Context(), query)
+	if err != nil {
+		log.Printf("failed to fetch session metrics: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "failed to fetch session metrics",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, MetricsResponse{
+		From:                params.From,
+		To:                  params.To,
+		Channel:             params.Channel,
+		Sessions:            metrics.Sessions,
+		Bounces:             metrics.Bounces,
+		BounceRate:          metrics.BounceRate(),
+		AvgDurationSeconds:  metrics.AvgDuration.Seconds(),
+		AvgEventsPerSession: metrics.AvgEventsPerSession,
+	})
+}
+
+func (h *Handler) RegisterRoutes(r gin.IRoutes) {
+	r.GET("/metrics/sessions", h.GetMetrics)
+}
@@ -0,0 +1,25 @@
+package sessions
+
+import "time"
+
+type MetricsQuery struct {
+	From    *time.Time
+	To      *time.Time
+	Channel string
+}
+
+type Metrics struct {
+	Sessions            uint64
+	Bounces             uint64
+	AvgDuration         time.Duration
+	AvgEventsPerSession float64
+}
+
+// BounceRate is the share of sessions with a single event, or zero when
+// there are no sessions.
+func (m Metrics) BounceRate() float64 {
+	if m.Sessions == 0 {
+		return 0
+	}
+	return float64(m.Bounces) / float64(m.Sessions)
+}
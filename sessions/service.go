@@ -0,0 +1,150 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+// sessionizerLease is the lease held by the one instance that runs the
+// sessionizer.
+const sessionizerLease = "sessionizer"
+
+type sessionsRepository interface {
+	BuildSessions(ctx context.Context, window repository.SessionWindow) error
+	GetSessionMetrics(ctx context.Context, filter repository.SessionsFilter) (repository.SessionMetricsRow, error)
+}
+
+type leaseRepository interface {
+	AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, owner string) error
+}
+
+// Service runs the sessionizer and answers session metrics queries. The
+// sessionizer periodically turns stored events into sessions once they have
+// been inactive for the configured gap; sessions still in progress are
+// picked up by a later run. Every instance runs the loop, but only the one
+// holding the sessionizer lease does the work.
+type Service struct {
+	repo   sessionsRepository
+	leases leaseRepository
+	cfg    config.SessionsConfig
+	owner  string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewService(repo sessionsRepository, leases leaseRepository, cfg config.SessionsConfig) *Service {
+	return &Service{
+		repo:   repo,
+		leases: leases,
+		cfg:    cfg,
+		owner:  uuid.NewString(),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs the sessionizer in the background until Close is called.
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Close stops the sessionizer, waits for a running pass to finish and hands
+// the lease on to another instance.
+func (s *Service) Close() {
+	close(s.done)
+	s.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.leases.ReleaseLease(ctx, sessionizerLease, s.owner); err != nil {
+		log.Printf("failed to release sessionizer lease: %v", err)
+	}
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	for {
+		if err := s.run(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("failed to sessionize events: %v", err)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run sessionizes if this instance holds the sessionizer lease, claiming or
+// renewing it first. The lease outlasts a few missed ticks, so it only moves
+// when its holder stops.
+func (s *Service) run(ctx context.Context, now time.Time) error {
+	held, err := s.leases.AcquireLease(ctx, sessionizerLease, s.owner, 3*s.cfg.Interval)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return nil
+	}
+	return s.Sessionize(ctx, now)
+}
+
+// Sessionize rebuilds the sessions that closed within the lookback before
+// now, so events that arrive late, e.g. behind Kafka lag, are added to
+// their sessions. A further lookback of earlier events is read so that
+// long sessions keep their first event.
+func (s *Service) Sessionize(ctx context.Context, now time.Time) error {
+	since := now.Add(-s.cfg.Lookback)
+	closedBefore := now.Add(-s.cfg.InactivityGap)
+	if !closedBefore.After(since) {
+		return nil
+	}
+
+	return s.repo.BuildSessions(ctx, repository.SessionWindow{
+		From:         since.Add(-s.cfg.Lookback),
+		To:           now,
+		Since:        since,
+		ClosedBefore: closedBefore,
+		Gap:          s.cfg.InactivityGap,
+	})
+}
+
+func (s *Service) GetMetrics(ctx context.Context, query MetricsQuery) (Metrics, error) {
+	row, err := s.repo.GetSessionMetrics(ctx, repository.SessionsFilter{
+		StartTime: query.From,
+		EndTime:   query.To,
+		Channel:   query.Channel,
+	})
+	if err != nil {
+		return Metrics{}, fmt.Errorf("failed to get session metrics: %w", err)
+	}
+
+	return Metrics{
+		Sessions:            row.Sessions,
+		Bounces:             row.Bounces,
+		AvgDuration:         time.Duration(row.AvgDurationMs * float64(time.Millisecond)),
+		AvgEventsPerSession: row.AvgEventsCount,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+)
+
+type fakeSessionsRepository struct {
+	mu      sync.Mutex
+	windows []repository.SessionWindow
+}
+
+func (r *fakeSessionsRepository) BuildSessions(_ context.Context, window repository.SessionWindow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.windows = append(r.windows, window)
+	return nil
+}
+
+func (r *fakeSessionsRepository) GetSessionMetrics(context.Context, repository.SessionsFilter) (repository.SessionMetricsRow, error) {
+	return repository.SessionMetricsRow{}, nil
+}
+
+// fakeLeases grants each lease to the first owner that asks for it.
+type fakeLeases struct {
+	mu      sync.Mutex
+	holders map[string]string
+}
+
+func (l *fakeLeases) AcquireLease(_ context.Context, name, owner string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders == nil {
+		l.holders = map[string]string{}
+	}
+	if holder, ok := l.holders[name]; ok {
+		return holder == owner, nil
+	}
+	l.holders[name] = owner
+	return true, nil
+}
+
+func (l *fakeLeases) ReleaseLease(_ context.Context, name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders[name] == owner {
+		delete(l.holders, name)
+	}
+	return nil
+}
+
+var testConfig = config.SessionsConfig{
+	Enabled:       true,
+	InactivityGap: 30 * time.Minute,
+	Interval:      time.Minute,
+	Lookback:      24 * time.Hour,
+}
+
+func TestSessionizeRebuildsLookback(t *testing.T) {
+	repo := &fakeSessionsRepository{}
+	service := NewService(repo, &fakeLeases{}, testConfig)
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	if err := service.Sessionize(context.Background(), now); err != nil {
+		t.Fatalf("Sessionize() error = %v", err)
+	}
+
+	want := repository.SessionWindow{
+		From:         now.Add(-48 * time.Hour),
+		To:           now,
+		Since:        now.Add(-24 * time.Hour),
+		ClosedBefore: now.Add(-30 * time.Minute),
+		Gap:          30 * time.Minute,
+	}
+	if len(repo.windows) != 1 || repo.windows[0] != want {
+		t.Errorf("windows = %+v, want %+v", repo.windows, want)
+	}
+}
+
+func TestSessionizerRunsOnLeaseHolder(t *testing.T) {
+	repo := &fakeSessionsRepository{}
+	leases := &fakeLeases{}
+	first := NewService(repo, leases, testConfig)
+	second := NewService(repo, leases, testConfig)
+	now := time.Now()
+
+	for _, service := range []*Service{first, second, first} {
+		if err := service.run(context.Background(), now); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+	if len(repo.windows) != 2 {
+		t.Fatalf("sessionized %d times, want 2 by the lease holder", len(repo.windows))
+	}
+
+	// Once the holder stops, the other instance takes over.
+	first.Close()
+	if err := second.run(context.Background(), now); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(repo.windows) != 3 {
+		t.Errorf("sessionized %d times, want 3 after handover", len(repo.windows))
+	}
+}
@@ -0,0 +1,140 @@
+package users
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type UserParams struct {
+	UserID string `uri:"user_id" binding:"required"`
+}
+
+type DeletionParams struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+type DeletionResponse struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	RequestedAt int64  `json:"requested_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func toDeletionResponse(d Deletion) DeletionResponse {
+	return DeletionResponse{
+		ID:          d.ID,
+		UserID:      d.UserID,
+		Status:      d.Status,
+		Error:       d.Error,
+		RequestedAt: d.RequestedAt.Unix(),
+		UpdatedAt:   d.UpdatedAt.Unix(),
+	}
+}
+
+func (h *Handler) DeleteUser(c *gin.Context) {
+	var params UserParams
+
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	deletion, err := h.service.RequestDeletion(c.Request.Context(), params.UserID)
+	if err != nil {
+		log.Printf("failed to request user deletion: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, toDeletionResponse(deletion))
+}
+
+func (h *Handler) GetDeletion(c *gin.Context) {
+	var params DeletionParams
+
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	deletion, err := h.service.GetDeletion(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to fetch deletion: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if deletion == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "deletion not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, toDeletionResponse(*deletion))
+}
+
+// ExportUser streams every stored event of a user as newline-delimited JSON.
+func (h *Handler) ExportUser(c *gin.Context) {
+	var params UserParams
+
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// Large exports outlive the server's write timeout, so lift it for this
+	// request.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("failed to clear write deadline for user export: %v", err)
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="user-export.ndjson"`)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	err := h.service.ExportUserEvents(c.Request.Context(), params.UserID, func(event ExportedEvent) error {
+		return enc.Encode(event)
+	})
+	if err != nil {
+		// Headers are already sent, so the truncated body is the only signal
+		// the client gets.
+		log.Printf("failed to export user events: %v", err)
+	}
+}
+
+func (h *Handler) RegisterRoutes(r gin.IRoutes) {
+	r.DELETE("/users/:user_id", h.DeleteUser)
+	r.GET("/users/:user_id/export", h.ExportUser)
+	r.GET("/admin/deletions/:id", h.GetDeletion)
+}
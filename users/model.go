@@ -0,0 +1,41 @@
+package users
+
+import "time"
+
+const (
+	DeletionPending   = "pending"
+	DeletionRunning   = "running"
+	DeletionCompleted = "completed"
+	DeletionFailed    = "failed"
+)
+
+type Deletion struct {
+	ID          string
+	UserID      string
+	Status      string
+	Error       string
+	RequestedAt time.Time
+	UpdatedAt   time.Time
+}
+
+type ExportedEvent struct {
+	EventHash    uint64         `json:"event_hash"`
+	EventID      string         `json:"event_id,omitempty"`
+	EventName    string         `json:"event_name"`
+	Channel      string         `json:"channel"`
+	CampaignID   string         `json:"campaign_id"`
+	UserID       string         `json:"user_id"`
+	SessionID    string         `json:"session_id,omitempty"`
+	Timestamp    int64          `json:"timestamp"`
+	TimestampMs  int64          `json:"timestamp_ms"`
+	Tags         []string       `json:"tags"`
+	Metadata     map[string]any `json:"metadata"`
+	ReceivedAt   int64          `json:"received_at"`
+	ClientIP     string         `json:"client_ip"`
+	DeviceType   string         `json:"device_type"`
+	OS           string         `json:"os"`
+	Browser      string         `json:"browser"`
+	Country      string         `json:"country"`
+	City         string         `json:"city"`
+	CampaignName string         `json:"campaign_name"`
+}
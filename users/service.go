@@ -0,0 +1,273 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+)
+
+// deletionLeaseTTL is how long a deletion stays claimed by an instance
+// that stops renewing it, e.g. because it crashed.
+const deletionLeaseTTL = time.Minute
+
+type usersRepository interface {
+	SaveDeletion(ctx context.Context, row repository.DeletionRow) error
+	GetDeletion(ctx context.Context, id string) (*repository.DeletionRow, error)
+	ListDeletionsByStatus(ctx context.Context, statuses ...string) ([]repository.DeletionRow, error)
+	DeleteUserEvents(ctx context.Context, userIDs []string) error
+	StreamUserEvents(ctx context.Context, userIDs []string, fn func(repository.EventRow) error) error
+	EventTimeRange(ctx context.Context) (time.Time, time.Time, bool, error)
+}
+
+type leaseRepository interface {
+	AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, owner string) error
+}
+
+// userIDHasher maps a user ID to every form it may be stored under, since
+// user IDs can be hashed with a salt that rotates over time.
+type userIDHasher interface {
+	UserIDHashes(userID string, from, to time.Time) ([]string, error)
+}
+
+type Service struct {
+	repo    usersRepository
+	leases  leaseRepository
+	userIDs userIDHasher
+	owner   string
+	wg      sync.WaitGroup
+}
+
+func NewService(repo usersRepository, leases leaseRepository, userIDs userIDHasher) *Service {
+	return &Service{
+		repo:    repo,
+		leases:  leases,
+		userIDs: userIDs,
+		owner:   uuid.NewString(),
+	}
+}
+
+// RequestDeletion records a right-to-erasure request and runs it in the
+// background. Progress is tracked through GetDeletion.
+func (s *Service) RequestDeletion(ctx context.Context, userID string) (Deletion, error) {
+	now := time.Now().UTC()
+	deletion := Deletion{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Status:      DeletionPending,
+		RequestedAt: now,
+		UpdatedAt:   now,
+	}
+
+	if err := s.save(ctx, deletion); err != nil {
+		return Deletion{}, fmt.Errorf("failed to record deletion request: %w", err)
+	}
+
+	s.start(deletion)
+	return deletion, nil
+}
+
+func (s *Service) GetDeletion(ctx context.Context, id string) (*Deletion, error) {
+	row, err := s.repo.GetDeletion(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deletion: %w", err)
+	}
+	if row == nil {
+		return nil, nil
+	}
+
+	deletion := Deletion(*row)
+	return &deletion, nil
+}
+
+// ResumeDeletions restarts requests that were left unfinished, e.g. by a
+// restart mid-run. Deletes are idempotent, so rerunning them is safe, and
+// each one is claimed first so that replicas starting together do not all
+// run it.
+func (s *Service) ResumeDeletions(ctx context.Context) error {
+	rows, err := s.repo.ListDeletionsByStatus(ctx, DeletionPending, DeletionRunning)
+	if err != nil {
+		return fmt.Errorf("failed to list unfinished deletions: %w", err)
+	}
+
+	for _, row := range rows {
+		s.start(Deletion(row))
+	}
+	return nil
+}
+
+// Wait blocks until all running deletions have finished or ctx is done.
+// Deletions cut short are resumed by the next instance to start once their
+// lease expires.
+func (s *Service) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) start(deletion Deletion) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(context.Background(), deletion)
+	}()
+}
+
+func (s *Service) run(ctx context.Context, deletion Deletion) {
+	lease := "user_deletion/" + deletion.ID
+	held, err := s.leases.AcquireLease(ctx, lease, s.owner, deletionLeaseTTL)
+	if err != nil {
+		log.Printf("failed to claim deletion %s: %v", deletion.ID, err)
+		return
+	}
+	if !held {
+		return
+	}
+	stop := s.renewLease(lease)
+	defer func() {
+		stop()
+		if err := s.leases.ReleaseLease(ctx, lease, s.owner); err != nil {
+			log.Printf("failed to release deletion %s: %v", deletion.ID, err)
+		}
+	}()
+
+	// Another instance may have finished the deletion since it was listed.
+	current, err := s.repo.GetDeletion(ctx, deletion.ID)
+	if err != nil {
+		log.Printf("failed to get deletion %s: %v", deletion.ID, err)
+		return
+	}
+	if current != nil && (current.Status == DeletionCompleted || current.Status == DeletionFailed) {
+		return
+	}
+
+	deletion.Status = DeletionRunning
+	if err := s.save(ctx, deletion); err != nil {
+		log.Printf("failed to update deletion %s: %v", deletion.ID, err)
+	}
+
+	deletion.Status = DeletionCompleted
+	if err := s.deleteUserEvents(ctx, deletion.UserID); err != nil {
+		log.Printf("failed to delete events for deletion %s: %v", deletion.ID, err)
+		deletion.Status = DeletionFailed
+		deletion.Error = err.Error()
+	}
+
+	if err := s.save(ctx, deletion); err != nil {
+		log.Printf("failed to update deletion %s: %v", deletion.ID, err)
+	}
+}
+
+func (s *Service) deleteUserEvents(ctx context.Context, userID string) error {
+	userIDs, err := s.storedUserIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.repo.DeleteUserEvents(ctx, userIDs)
+}
+
+// storedUserIDs returns every user_id a user's events may be stored under.
+func (s *Service) storedUserIDs(ctx context.Context, userID string) ([]string, error) {
+	from, to, ok, err := s.repo.EventTimeRange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		from, to = time.Now(), time.Now()
+	}
+	return s.userIDs.UserIDHashes(userID, from, to)
+}
+
+// renewLease keeps a lease held while a deletion runs, until the returned
+// function is called.
+func (s *Service) renewLease(lease string) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(deletionLeaseTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), deletionLeaseTTL/3)
+				if held, err := s.leases.AcquireLease(ctx, lease, s.owner, deletionLeaseTTL); err != nil {
+					log.Printf("failed to renew %s: %v", lease, err)
+				} else if !held {
+					log.Printf("lost %s to another instance", lease)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (s *Service) save(ctx context.Context, deletion Deletion) error {
+	deletion.UpdatedAt = time.Now().UTC()
+	return s.repo.SaveDeletion(ctx, repository.DeletionRow(deletion))
+}
+
+// ExportUserEvents calls fn for every stored event of a user.
+func (s *Service) ExportUserEvents(ctx context.Context, userID string, fn func(ExportedEvent) error) error {
+	userIDs, err := s.storedUserIDs(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to export user events: %w", err)
+	}
+
+	err = s.repo.StreamUserEvents(ctx, userIDs, func(row repository.EventRow) error {
+		event := ExportedEvent{
+			EventHash:    row.EventHash,
+			EventID:      row.EventID,
+			EventName:    row.EventName,
+			Channel:      row.Channel,
+			CampaignID:   row.CampaignID,
+			UserID:       row.UserID,
+			SessionID:    row.SessionID,
+			Timestamp:    row.Timestamp.Unix(),
+			TimestampMs:  row.EventTime.UnixMilli(),
+			Tags:         row.Tags,
+			ReceivedAt:   row.ReceivedAt.UnixMilli(),
+			ClientIP:     row.ClientIP,
+			DeviceType:   row.DeviceType,
+			OS:           row.OS,
+			Browser:      row.Browser,
+			Country:      row.Country,
+			City:         row.City,
+			CampaignName: row.CampaignName,
+		}
+		if err := json.Unmarshal([]byte(row.Metadata), &event.Metadata); err != nil {
+			return fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+		return fn(event)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export user events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+)
+
+type fakeUsersRepository struct {
+	mu        sync.Mutex
+	saved     []repository.DeletionRow
+	deleted   [][]string
+	unhandled []repository.DeletionRow
+}
+
+func (r *fakeUsersRepository) SaveDeletion(_ context.Context, row repository.DeletionRow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saved = append(r.saved, row)
+	return nil
+}
+
+func (r *fakeUsersRepository) GetDeletion(context.Context, string) (*repository.DeletionRow, error) {
+	return nil, nil
+}
+
+func (r *fakeUsersRepository) ListDeletionsByStatus(context.Context, ...string) ([]repository.DeletionRow, error) {
+	return r.unhandled, nil
+}
+
+func (r *fakeUsersRepository) DeleteUserEvents(_ context.Context, userIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleted = append(r.deleted, userIDs)
+	return nil
+}
+
+func (r *fakeUsersRepository) StreamUserEvents(context.Context, []string, func(repository.EventRow) error) error {
+	return nil
+}
+
+func (r *fakeUsersRepository) EventTimeRange(context.Context) (time.Time, time.Time, bool, error) {
+	return time.Time{}, time.Time{}, false, nil
+}
+
+// fakeLeases grants each lease to the first owner that asks for it.
+type fakeLeases struct {
+	mu      sync.Mutex
+	holders map[string]string
+}
+
+func (l *fakeLeases) AcquireLease(_ context.Context, name, owner string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders == nil {
+		l.holders = map[string]string{}
+	}
+	if holder, ok := l.holders[name]; ok {
+		return holder == owner, nil
+	}
+	l.holders[name] = owner
+	return true, nil
+}
+
+func (l *fakeLeases) ReleaseLease(context.Context, string, string) error {
+	return nil
+}
+
+type fakeHasher struct{}
+
+func (fakeHasher) UserIDHashes(userID string, _, _ time.Time) ([]string, error) {
+	return []string{userID, "hashed-" + userID}, nil
+}
+
+func TestDeletionUsesStoredUserIDs(t *testing.T) {
+	repo := &fakeUsersRepository{}
+	service := NewService(repo, &fakeLeases{}, fakeHasher{})
+
+	if _, err := service.RequestDeletion(context.Background(), "user-1"); err != nil {
+		t.Fatalf("RequestDeletion() error = %v", err)
+	}
+	if err := service.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	if len(repo.deleted) != 1 || len(repo.deleted[0]) != 2 || repo.deleted[0][1] != "hashed-user-1" {
+		t.Fatalf("deleted %v, want the raw and hashed user IDs", repo.deleted)
+	}
+	if last := repo.saved[len(repo.saved)-1]; last.Status != DeletionCompleted {
+		t.Errorf("final status = %s, want %s", last.Status, DeletionCompleted)
+	}
+}
+
+func TestResumeDeletionsRunsEachOnce(t *testing.T) {
+	repo := &fakeUsersRepository{
+		unhandled: []repository.DeletionRow{{ID: "d1", UserID: "user-1", Status: DeletionRunning}},
+	}
+	leases := &fakeLeases{}
+	first := NewService(repo, leases, fakeHasher{})
+	second := NewService(repo, leases, fakeHasher{})
+
+	for _, service := range []*Service{first, second} {
+		if err := service.ResumeDeletions(context.Background()); err != nil {
+			t.Fatalf("ResumeDeletions() error = %v", err)
+		}
+		if err := service.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+
+	if len(repo.deleted) != 1 {
+		t.Errorf("deletion ran %d times, want once", len(repo.deleted))
+	}
+}
+
+func TestWaitGivesUpAtDeadline(t *testing.T) {
+	service := NewService(&fakeUsersRepository{}, &fakeLeases{}, fakeHasher{})
+	service.wg.Add(1)
+	defer service.wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := service.Wait(ctx); err == nil {
+		t.Error("Wait() returned nil while a deletion was running")
+	}
+}
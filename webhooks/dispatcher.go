@@ -0,0 +1,452 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/kafka"
+)
+
+const (
+	// retryBatchSize caps the deliveries retried per pass; the rest wait
+	// for the next pass.
+	retryBatchSize = 500
+
+	// retrierLease is the lease held by the one instance that retries due
+	// deliveries.
+	retrierLease = "webhook_retrier"
+
+	saveTimeout = 5 * time.Second
+)
+
+// Send queues events for delivery without blocking. Events no active
+// subscription wants are skipped. Events that arrive while the queue is
+// full are stored as pending deliveries in the background instead, for the
+// retry pass to send; only when that falls behind by another queue's worth
+// are events dropped, and the drops are logged.
+func (s *Service) Send(msgs ...kafka.EventMessage) {
+	set := s.subscriptions.Load()
+	if set == nil {
+		return
+	}
+
+	for _, msg := range msgs {
+		if _, ok := set.eventNames[msg.EventName]; !ok {
+			continue
+		}
+		select {
+		case s.queue <- msg:
+		default:
+			s.setAside(msg)
+		}
+	}
+}
+
+func (s *Service) setAside(msg kafka.EventMessage) {
+	s.mu.Lock()
+	if len(s.overflow) >= max(s.cfg.QueueSize, 1) {
+		s.mu.Unlock()
+		s.dropped.Add(1)
+	} else {
+		s.overflow = append(s.overflow, msg)
+		s.mu.Unlock()
+	}
+
+	select {
+	case s.spill <- struct{}{}:
+	default:
+	}
+}
+
+// Start delivers queued events and retries failed deliveries in the
+// background until Close is called.
+func (s *Service) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	s.wg.Add(3 + s.cfg.Workers)
+	go s.refreshLoop(ctx)
+	go s.retryLoop(ctx)
+	go s.spillLoop(ctx)
+	for range s.cfg.Workers {
+		go s.worker(ctx)
+	}
+}
+
+// Close stops delivering and waits for attempts in flight to be recorded.
+// Events still queued are stored as pending deliveries, so that the retry
+// pass on this or another instance sends them, and the retrier lease is
+// handed on.
+func (s *Service) Close() {
+	close(s.done)
+	s.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+
+	var msgs []kafka.EventMessage
+	for len(s.queue) > 0 {
+		msgs = append(msgs, <-s.queue)
+	}
+	s.storeOverflow(ctx, msgs...)
+
+	if err := s.leases.ReleaseLease(ctx, retrierLease, s.owner); err != nil {
+		log.Printf("failed to release webhook retrier lease: %v", err)
+	}
+}
+
+func (s *Service) refreshLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to refresh webhook subscriptions: %v", err)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh reloads the active subscriptions. On failure the previous ones
+// stay in use.
+func (s *Service) refresh(ctx context.Context) error {
+	subscriptions, err := s.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	set := &subscriptionSet{eventNames: make(map[string]struct{})}
+	for _, sub := range subscriptions {
+		if sub.Status != StatusActive {
+			continue
+		}
+		set.list = append(set.list, sub)
+		set.eventNames[sub.EventName] = struct{}{}
+	}
+	s.subscriptions.Store(set)
+	return nil
+}
+
+func (s *Service) retryLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.retry(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("failed to retry webhook deliveries: %v", err)
+		}
+	}
+}
+
+// retry runs a retry pass if this instance holds the retrier lease,
+// claiming or renewing it first. The lease outlasts a few missed ticks, so
+// it only moves when its holder stops.
+func (s *Service) retry(ctx context.Context, now time.Time) error {
+	held, err := s.leases.AcquireLease(ctx, retrierLease, s.owner, 3*s.cfg.RetryInterval)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return nil
+	}
+	return s.RetryDue(ctx, now)
+}
+
+// spillLoop stores the events set aside while the queue was full.
+func (s *Service) spillLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.spill:
+		}
+		s.storeOverflow(ctx)
+	}
+}
+
+// storeOverflow stores the events set aside, and any others given, as
+// pending deliveries that are due at once.
+func (s *Service) storeOverflow(ctx context.Context, msgs ...kafka.EventMessage) {
+	s.mu.Lock()
+	msgs = append(msgs, s.overflow...)
+	s.overflow = nil
+	s.mu.Unlock()
+
+	if n := s.dropped.Swap(0); n > 0 {
+		log.Printf("webhook queue and overflow full: dropped %d events", n)
+	}
+
+	set := s.subscriptions.Load()
+	if set == nil || len(msgs) == 0 {
+		return
+	}
+
+	var rows []repository.WebhookDeliveryRow
+	for i := range msgs {
+		_, deliveries := s.newDeliveries(set, &msgs[i])
+		for _, delivery := range deliveries {
+			rows = append(rows, repository.WebhookDeliveryRow(delivery))
+		}
+	}
+
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveTimeout)
+	defer cancel()
+	if err := s.repo.SaveWebhookDeliveries(saveCtx, rows); err != nil {
+		log.Printf("failed to store %d webhook deliveries: %v", len(rows), err)
+	}
+}
+
+// RetryDue attempts the pending deliveries that are due by now, whichever
+// instance created them. Deliveries of subscriptions that are not active
+// wait until the subscription is active again.
+func (s *Service) RetryDue(ctx context.Context, now time.Time) error {
+	set := s.subscriptions.Load()
+	if set == nil {
+		return nil
+	}
+
+	byID := make(map[string]*Subscription, len(set.list))
+	ids := make([]string, 0, len(set.list))
+	for i := range set.list {
+		byID[set.list[i].ID] = &set.list[i]
+		ids = append(ids, set.list[i].ID)
+	}
+
+	rows, err := s.repo.ListDueWebhookDeliveries(ctx, ids, now.UTC(), retryBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list due webhook deliveries: %w", err)
+	}
+
+	var g errgroup.Group
+	g.SetLimit(s.cfg.Workers)
+	for _, row := range rows {
+		delivery := Delivery(row)
+		sub, ok := byID[delivery.SubscriptionID]
+		if !ok {
+			continue
+		}
+		g.Go(func() error {
+			s.attempt(ctx, sub, &delivery)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func (s *Service) worker(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-s.queue:
+			s.dispatch(ctx, &msg)
+		}
+	}
+}
+
+// dispatch sends an event to every active subscription it matches. The
+// deliveries are stored as pending first, due once the attempts could have
+// finished, so that the retry pass sends them if this instance stops
+// before recording the outcome.
+func (s *Service) dispatch(ctx context.Context, msg *kafka.EventMessage) {
+	set := s.subscriptions.Load()
+	if set == nil {
+		return
+	}
+
+	subs, deliveries := s.newDeliveries(set, msg)
+	if len(deliveries) == 0 {
+		return
+	}
+
+	claimedUntil := time.Now().UTC().Add(time.Duration(len(deliveries))*s.cfg.Timeout + saveTimeout)
+	rows := make([]repository.WebhookDeliveryRow, len(deliveries))
+	for i := range deliveries {
+		deliveries[i].NextAttemptAt = claimedUntil
+		rows[i] = repository.WebhookDeliveryRow(deliveries[i])
+	}
+	if err := s.repo.SaveWebhookDeliveries(ctx, rows); err != nil {
+		log.Printf("failed to store webhook deliveries: %v", err)
+	}
+
+	for i := range deliveries {
+		s.attempt(ctx, subs[i], &deliveries[i])
+	}
+}
+
+// newDeliveries creates a pending delivery of the event to every active
+// subscription it matches. The event's metadata is decoded at most once,
+// and only if a subscription has conditions on it.
+func (s *Service) newDeliveries(set *subscriptionSet, msg *kafka.EventMessage) ([]*Subscription, []Delivery) {
+	var subs []*Subscription
+	var deliveries []Delivery
+
+	var metadata map[string]any
+	var decoded bool
+	decode := func() map[string]any {
+		if !decoded {
+			decoded = true
+			json.Unmarshal([]byte(msg.Metadata), &metadata)
+		}
+		return metadata
+	}
+
+	for i := range set.list {
+		sub := &set.list[i]
+		if !sub.matches(msg, decode) {
+			continue
+		}
+
+		delivery, err := s.newDelivery(sub, msg)
+		if err != nil {
+			log.Printf("failed to create webhook delivery for subscription %s: %v", sub.ID, err)
+			continue
+		}
+		subs = append(subs, sub)
+		deliveries = append(deliveries, delivery)
+	}
+	return subs, deliveries
+}
+
+func (s *Service) newDelivery(sub *Subscription, msg *kafka.EventMessage) (Delivery, error) {
+	payload := Payload{
+		ID:             uuid.NewString(),
+		SubscriptionID: sub.ID,
+		Event: EventPayload{
+			EventID:    msg.EventID,
+			EventName:  msg.EventName,
+			Channel:    msg.Channel,
+			CampaignID: msg.CampaignID,
+			UserID:     msg.UserID,
+			SessionID:  msg.SessionID,
+			Timestamp:  time.UnixMilli(msg.TimestampMs).UTC(),
+			Tags:       msg.Tags,
+			ReceivedAt: time.UnixMilli(msg.ReceivedAt).UTC(),
+		},
+	}
+	if msg.Metadata != "" && msg.Metadata != "null" {
+		payload.Event.Metadata = json.RawMessage(msg.Metadata)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	now := time.Now().UTC()
+	return Delivery{
+		ID:             payload.ID,
+		SubscriptionID: sub.ID,
+		EventName:      msg.EventName,
+		EventHash:      msg.EventHash,
+		UserID:         msg.UserID,
+		Payload:        string(body),
+		Status:         DeliveryPending,
+		NextAttemptAt:  now,
+		Instance:       s.instance,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
+}
+
+// attempt sends a delivery once and stores the outcome. A delivery whose
+// last attempt fails is dead, and its subscription is moved to the dead
+// letter state so a broken endpoint stops receiving events.
+func (s *Service) attempt(ctx context.Context, sub *Subscription, delivery *Delivery) {
+	code, err := s.sender.Send(ctx, sub.URL, sub.Secret, delivery.ID, []byte(delivery.Payload))
+
+	now := time.Now().UTC()
+	delivery.LastStatusCode = uint16(code)
+	delivery.Instance = s.instance
+	delivery.UpdatedAt = now
+
+	switch {
+	case err == nil:
+		delivery.Attempts++
+		delivery.Status = DeliveryDelivered
+		delivery.LastError = ""
+	case ctx.Err() != nil:
+		// Interrupted by shutdown, which does not count as an attempt.
+		delivery.Status = DeliveryPending
+		delivery.NextAttemptAt = now
+		delivery.LastError = err.Error()
+	default:
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if int(delivery.Attempts) >= s.cfg.MaxAttempts {
+			delivery.Status = DeliveryDead
+		} else {
+			delivery.Status = DeliveryPending
+			delivery.NextAttemptAt = now.Add(s.backoff(delivery.Attempts))
+		}
+	}
+
+	// The outcome is recorded even when ctx was cancelled, so that an
+	// interrupted delivery is retried rather than lost.
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveTimeout)
+	defer cancel()
+
+	if err := s.repo.SaveWebhookDelivery(saveCtx, repository.WebhookDeliveryRow(*delivery)); err != nil {
+		log.Printf("failed to save webhook delivery %s: %v", delivery.ID, err)
+	}
+	if delivery.Status == DeliveryDead {
+		s.deadLetter(saveCtx, sub.ID)
+	}
+}
+
+// backoff returns the wait before the next attempt after the given number
+// of failed attempts.
+func (s *Service) backoff(attempts uint32) time.Duration {
+	wait := s.cfg.RetryBackoff
+	for i := uint32(1); i < attempts && wait < s.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.cfg.MaxBackoff)
+}
+
+func (s *Service) deadLetter(ctx context.Context, id string) {
+	sub, err := s.GetSubscription(ctx, id)
+	if err != nil {
+		log.Printf("failed to dead-letter webhook subscription %s: %v", id, err)
+		return
+	}
+	if sub == nil || sub.Status != StatusActive {
+		return
+	}
+
+	sub.Status = StatusDeadLetter
+	if _, err := s.SaveSubscription(ctx, *sub); err != nil {
+		log.Printf("failed to dead-letter webhook subscription %s: %v", id, err)
+		return
+	}
+	log.Printf("webhook subscription %s moved to dead letter after a delivery failed %d times", id, s.cfg.MaxAttempts)
+}
@@ -0,0 +1,237 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+	"github.com/insider/event-ingestion/kafka"
+)
+
+// fakeWebhooksRepository keeps the latest version of each delivery.
+type fakeWebhooksRepository struct {
+	mu         sync.Mutex
+	deliveries map[string]repository.WebhookDeliveryRow
+}
+
+func (r *fakeWebhooksRepository) ListWebhookSubscriptions(context.Context) ([]repository.WebhookSubscriptionRow, error) {
+	return nil, nil
+}
+
+func (r *fakeWebhooksRepository) GetWebhookSubscription(context.Context, string) (*repository.WebhookSubscriptionRow, error) {
+	return nil, nil
+}
+
+func (r *fakeWebhooksRepository) SaveWebhookSubscription(context.Context, repository.WebhookSubscriptionRow) error {
+	return nil
+}
+
+func (r *fakeWebhooksRepository) DeleteWebhookSubscription(context.Context, string) error {
+	return nil
+}
+
+func (r *fakeWebhooksRepository) SaveWebhookDelivery(ctx context.Context, row repository.WebhookDeliveryRow) error {
+	return r.SaveWebhookDeliveries(ctx, []repository.WebhookDeliveryRow{row})
+}
+
+func (r *fakeWebhooksRepository) SaveWebhookDeliveries(_ context.Context, rows []repository.WebhookDeliveryRow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deliveries == nil {
+		r.deliveries = map[string]repository.WebhookDeliveryRow{}
+	}
+	for _, row := range rows {
+		r.deliveries[row.ID] = row
+	}
+	return nil
+}
+
+func (r *fakeWebhooksRepository) GetWebhookDelivery(_ context.Context, id string) (*repository.WebhookDeliveryRow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	return &row, nil
+}
+
+func (r *fakeWebhooksRepository) ListWebhookDeliveries(context.Context, repository.WebhookDeliveriesFilter) ([]repository.WebhookDeliveryRow, error) {
+	return nil, nil
+}
+
+func (r *fakeWebhooksRepository) ListDueWebhookDeliveries(_ context.Context, _ []string, now time.Time, _ int) ([]repository.WebhookDeliveryRow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []repository.WebhookDeliveryRow
+	for _, row := range r.deliveries {
+		if row.Status == DeliveryPending && !row.NextAttemptAt.After(now) {
+			due = append(due, row)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeWebhooksRepository) byStatus(status string) []repository.WebhookDeliveryRow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []repository.WebhookDeliveryRow
+	for _, row := range r.deliveries {
+		if row.Status == status {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// fakeLeases grants each lease to the first owner that asks for it.
+type fakeLeases struct {
+	mu      sync.Mutex
+	holders map[string]string
+}
+
+func (l *fakeLeases) AcquireLease(_ context.Context, name, owner string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders == nil {
+		l.holders = map[string]string{}
+	}
+	if holder, ok := l.holders[name]; ok {
+		return holder == owner, nil
+	}
+	l.holders[name] = owner
+	return true, nil
+}
+
+func (l *fakeLeases) ReleaseLease(_ context.Context, name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders[name] == owner {
+		delete(l.holders, name)
+	}
+	return nil
+}
+
+var testConfig = config.WebhooksConfig{
+	Enabled:       true,
+	Workers:       1,
+	QueueSize:     1,
+	Timeout:       time.Second,
+	MaxAttempts:   3,
+	RetryBackoff:  time.Second,
+	MaxBackoff:    time.Minute,
+	RetryInterval: time.Second,
+}
+
+func newTestService(repo *fakeWebhooksRepository, leases *fakeLeases, url string) *Service {
+	s := NewService(repo, leases, NewSender(time.Second), testConfig)
+	sub := Subscription{ID: "sub-1", EventName: "purchase", URL: url, Secret: "whsec_test_secret", Status: StatusActive}
+	s.subscriptions.Store(&subscriptionSet{
+		list:       []Subscription{sub},
+		eventNames: map[string]struct{}{sub.EventName: {}},
+	})
+	return s
+}
+
+func purchase(userID string) kafka.EventMessage {
+	return kafka.EventMessage{EventName: "purchase", UserID: userID, TimestampMs: 1700000000000}
+}
+
+func TestDispatchStoresDeliveryBeforeAttempt(t *testing.T) {
+	repo := &fakeWebhooksRepository{}
+	var stored *repository.WebhookDeliveryRow
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stored, _ = repo.GetWebhookDelivery(r.Context(), r.Header.Get(IDHeader))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := newTestService(repo, &fakeLeases{}, server.URL)
+	msg := purchase("user-1")
+	s.dispatch(context.Background(), &msg)
+
+	if stored == nil || stored.Status != DeliveryPending {
+		t.Fatalf("stored delivery at first attempt = %+v, want a pending one", stored)
+	}
+	if !stored.NextAttemptAt.After(time.Now()) {
+		t.Errorf("pending delivery is due at %v, want after the attempt could finish", stored.NextAttemptAt)
+	}
+	if delivered := repo.byStatus(DeliveryDelivered); len(delivered) != 1 {
+		t.Errorf("got %d delivered deliveries, want 1", len(delivered))
+	}
+}
+
+func TestSendStoresOverflow(t *testing.T) {
+	repo := &fakeWebhooksRepository{}
+	s := newTestService(repo, &fakeLeases{}, "http://localhost")
+
+	s.Send(purchase("user-1"), purchase("user-2"), purchase("user-3"), purchase("user-4"))
+	if len(s.queue) != 1 {
+		t.Fatalf("queued %d events, want 1", len(s.queue))
+	}
+
+	s.storeOverflow(context.Background())
+	pending := repo.byStatus(DeliveryPending)
+	if len(pending) != 1 {
+		t.Fatalf("stored %d pending deliveries, want 1 set aside", len(pending))
+	}
+	if pending[0].NextAttemptAt.After(time.Now()) {
+		t.Errorf("overflow delivery is due at %v, want at once", pending[0].NextAttemptAt)
+	}
+	if got := s.dropped.Load(); got != 0 {
+		t.Errorf("dropped = %d after logging, want the count reset", got)
+	}
+}
+
+func TestCloseStoresQueuedEvents(t *testing.T) {
+	repo := &fakeWebhooksRepository{}
+	s := newTestService(repo, &fakeLeases{}, "http://localhost")
+
+	s.Send(purchase("user-1"), purchase("user-2"))
+	s.Close()
+
+	if pending := repo.byStatus(DeliveryPending); len(pending) != 2 {
+		t.Errorf("stored %d pending deliveries at shutdown, want 2", len(pending))
+	}
+}
+
+func TestRetryDueOnLeaseHolder(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	repo := &fakeWebhooksRepository{}
+	leases := &fakeLeases{}
+	creator := newTestService(repo, leases, server.URL)
+	creator.instance = "creator"
+	creator.storeOverflow(context.Background(), purchase("user-1"))
+
+	// The creating instance holds no lease, so another instance retries.
+	first := newTestService(repo, leases, server.URL)
+	second := newTestService(repo, leases, server.URL)
+	first.instance = "first"
+	for _, s := range []*Service{first, second} {
+		if err := s.retry(context.Background(), time.Now()); err != nil {
+			t.Fatalf("retry() error = %v", err)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("sent %d requests, want 1 by the lease holder", requests)
+	}
+	delivered := repo.byStatus(DeliveryDelivered)
+	if len(delivered) != 1 || delivered[0].Instance != "first" {
+		t.Errorf("delivered = %+v, want one delivered by first", delivered)
+	}
+}
@@ -0,0 +1,149 @@
+package webhooks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/insider/event-ingestion/kafka"
+)
+
+const (
+	OpEq     = "eq"
+	OpNe     = "ne"
+	OpGt     = "gt"
+	OpGte    = "gte"
+	OpLt     = "lt"
+	OpLte    = "lte"
+	OpExists = "exists"
+)
+
+// Condition compares one metadata field to Value. Field is a dot-separated
+// path into the event's metadata, such as "order.total". Ordering
+// operators need a numeric Value and also accept numeric strings in the
+// event, since clients often send prices as strings.
+type Condition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value any    `json:"value,omitempty"`
+}
+
+func (c *Condition) validate() error {
+	if c.Field == "" {
+		return fmt.Errorf("condition field is required")
+	}
+
+	switch c.Op {
+	case OpExists:
+	case OpEq, OpNe:
+		switch c.Value.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("condition on %q: value must be a string, number or boolean", c.Field)
+		}
+	case OpGt, OpGte, OpLt, OpLte:
+		if _, ok := c.Value.(float64); !ok {
+			return fmt.Errorf("condition on %q: %s requires a numeric value", c.Field, c.Op)
+		}
+	default:
+		return fmt.Errorf("condition on %q: unsupported op: %s", c.Field, c.Op)
+	}
+	return nil
+}
+
+func (c *Condition) matches(metadata map[string]any) bool {
+	actual, ok := lookup(metadata, c.Field)
+	if c.Op == OpExists {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+
+	switch c.Op {
+	case OpEq:
+		return equal(actual, c.Value)
+	case OpNe:
+		return !equal(actual, c.Value)
+	}
+
+	got, ok := toFloat(actual)
+	if !ok {
+		return false
+	}
+	want := c.Value.(float64)
+	switch c.Op {
+	case OpGt:
+		return got > want
+	case OpGte:
+		return got >= want
+	case OpLt:
+		return got < want
+	case OpLte:
+		return got <= want
+	}
+	return false
+}
+
+// matches reports whether msg belongs to the subscription. metadata is
+// only called, to decode the event's metadata, when there are conditions
+// to check.
+func (s *Subscription) matches(msg *kafka.EventMessage, metadata func() map[string]any) bool {
+	if msg.EventName != s.EventName {
+		return false
+	}
+	if s.Channel != "" && msg.Channel != s.Channel {
+		return false
+	}
+	if len(s.Conditions) == 0 {
+		return true
+	}
+
+	m := metadata()
+	for i := range s.Conditions {
+		if !s.Conditions[i].matches(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func lookup(metadata map[string]any, path string) (any, bool) {
+	var value any = metadata
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func equal(actual, want any) bool {
+	switch want := want.(type) {
+	case string:
+		s, ok := actual.(string)
+		return ok && s == want
+	case bool:
+		b, ok := actual.(bool)
+		return ok && b == want
+	case float64:
+		f, ok := toFloat(actual)
+		return ok && f == want
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/insider/event-ingestion/kafka"
+)
+
+func TestConditionMatches(t *testing.T) {
+	metadata := map[string]any{
+		"order": map[string]any{
+			"total":    "149.90",
+			"items":    float64(3),
+			"currency": "EUR",
+			"gift":     true,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		condition Condition
+		want      bool
+	}{
+		{"string eq", Condition{Field: "order.currency", Op: OpEq, Value: "EUR"}, true},
+		{"string ne", Condition{Field: "order.currency", Op: OpNe, Value: "USD"}, true},
+		{"bool eq", Condition{Field: "order.gift", Op: OpEq, Value: true}, true},
+		{"number eq", Condition{Field: "order.items", Op: OpEq, Value: float64(3)}, true},
+		{"numeric string gt", Condition{Field: "order.total", Op: OpGt, Value: float64(100)}, true},
+		{"numeric string lte", Condition{Field: "order.total", Op: OpLte, Value: float64(100)}, false},
+		{"gte boundary", Condition{Field: "order.items", Op: OpGte, Value: float64(3)}, true},
+		{"lt", Condition{Field: "order.items", Op: OpLt, Value: float64(3)}, false},
+		{"non-numeric string", Condition{Field: "order.currency", Op: OpGt, Value: float64(1)}, false},
+		{"exists", Condition{Field: "order.total", Op: OpExists}, true},
+		{"missing", Condition{Field: "order.coupon", Op: OpExists}, false},
+		{"missing eq", Condition{Field: "order.coupon", Op: OpEq, Value: "X"}, false},
+		{"path through a value", Condition{Field: "order.total.amount", Op: OpExists}, false},
+		{"type mismatch", Condition{Field: "order.items", Op: OpEq, Value: "3"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.condition.matches(metadata); got != tt.want {
+			t.Errorf("%s: matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+		wantErr   bool
+	}{
+		{"valid eq", Condition{Field: "a", Op: OpEq, Value: "x"}, false},
+		{"valid exists", Condition{Field: "a", Op: OpExists}, false},
+		{"missing field", Condition{Op: OpExists}, true},
+		{"ordering needs a number", Condition{Field: "a", Op: OpGt, Value: "10"}, true},
+		{"eq needs a scalar", Condition{Field: "a", Op: OpEq, Value: []any{"x"}}, true},
+		{"unknown op", Condition{Field: "a", Op: "contains", Value: "x"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.condition.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	sub := Subscription{
+		EventName:  "purchase",
+		Channel:    "web",
+		Conditions: []Condition{{Field: "total", Op: OpGte, Value: float64(50)}},
+	}
+	metadata := func(total float64) func() map[string]any {
+		return func() map[string]any { return map[string]any{"total": total} }
+	}
+
+	tests := []struct {
+		name     string
+		msg      kafka.EventMessage
+		metadata func() map[string]any
+		want     bool
+	}{
+		{"match", kafka.EventMessage{EventName: "purchase", Channel: "web"}, metadata(80), true},
+		{"condition fails", kafka.EventMessage{EventName: "purchase", Channel: "web"}, metadata(20), false},
+		{"other event", kafka.EventMessage{EventName: "view", Channel: "web"}, metadata(80), false},
+		{"other channel", kafka.EventMessage{EventName: "purchase", Channel: "app"}, metadata(80), false},
+	}
+	for _, tt := range tests {
+		if got := sub.matches(&tt.msg, tt.metadata); got != tt.want {
+			t.Errorf("%s: matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionSkipsMetadataWithoutConditions(t *testing.T) {
+	sub := Subscription{EventName: "purchase"}
+	msg := kafka.EventMessage{EventName: "purchase"}
+	decoded := false
+	if !sub.matches(&msg, func() map[string]any { decoded = true; return nil }) {
+		t.Error("matches() = false, want true")
+	}
+	if decoded {
+		t.Error("matches() decoded metadata without conditions")
+	}
+}
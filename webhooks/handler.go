@@ -0,0 +1,375 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+type IDParams struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+type SubscriptionRequest struct {
+	EventName  string      `json:"event_name" binding:"required"`
+	Channel    string      `json:"channel" binding:"omitempty"`
+	Conditions []Condition `json:"conditions" binding:"omitempty,max=20"`
+	URL        string      `json:"url" binding:"required,url"`
+	Secret     string      `json:"secret" binding:"omitempty,min=16"`
+	Status     string      `json:"status" binding:"omitempty,oneof=active paused"`
+}
+
+type SubscriptionResponse struct {
+	ID         string      `json:"id"`
+	EventName  string      `json:"event_name"`
+	Channel    string      `json:"channel,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	URL        string      `json:"url"`
+	Secret     string      `json:"secret,omitempty"`
+	Status     string      `json:"status"`
+	CreatedAt  int64       `json:"created_at"`
+	UpdatedAt  int64       `json:"updated_at"`
+}
+
+type DeliveriesQueryParams struct {
+	SubscriptionID string `form:"subscription_id" binding:"omitempty,uuid"`
+	Status         string `form:"status" binding:"omitempty,oneof=pending delivered dead"`
+	Limit          int    `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+type DeliveryResponse struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventName      string          `json:"event_name"`
+	Status         string          `json:"status"`
+	Attempts       uint32          `json:"attempts"`
+	NextAttemptAt  int64           `json:"next_attempt_at,omitempty"`
+	LastStatusCode uint16          `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      int64           `json:"created_at"`
+	UpdatedAt      int64           `json:"updated_at"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (r *SubscriptionRequest) toSubscription(id string) Subscription {
+	sub := Subscription{
+		ID:         id,
+		EventName:  r.EventName,
+		Channel:    r.Channel,
+		Conditions: r.Conditions,
+		URL:        r.URL,
+		Secret:     r.Secret,
+		Status:     r.Status,
+	}
+	if sub.Status == "" {
+		sub.Status = StatusActive
+	}
+	return sub
+}
+
+func toSubscriptionResponse(sub Subscription) SubscriptionResponse {
+	return SubscriptionResponse{
+		ID:         sub.ID,
+		EventName:  sub.EventName,
+		Channel:    sub.Channel,
+		Conditions: sub.Conditions,
+		URL:        sub.URL,
+		Status:     sub.Status,
+		CreatedAt:  sub.CreatedAt.Unix(),
+		UpdatedAt:  sub.UpdatedAt.Unix(),
+	}
+}
+
+func toDeliveryResponse(d Delivery) DeliveryResponse {
+	resp := DeliveryResponse{
+		ID:             d.ID,
+		SubscriptionID: d.SubscriptionID,
+		EventName:      d.EventName,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt.Unix(),
+		UpdatedAt:      d.UpdatedAt.Unix(),
+	}
+	if d.Status == DeliveryPending {
+		resp.NextAttemptAt = d.NextAttemptAt.Unix()
+	}
+	return resp
+}
+
+func (h *Handler) ListSubscriptions(c *gin.Context) {
+	subscriptions, err := h.service.ListSubscriptions(c.Request.Context())
+	if err != nil {
+		log.Printf("failed to list webhook subscriptions: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := make([]SubscriptionResponse, len(subscriptions))
+	for i, sub := range subscriptions {
+		resp[i] = toSubscriptionResponse(sub)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) GetSubscription(c *gin.Context) {
+	var params IDParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	sub, err := h.service.GetSubscription(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to fetch webhook subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if sub == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "webhook subscription not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, toSubscriptionResponse(*sub))
+}
+
+// CreateSubscription returns the secret only in its response, so that it
+// is not exposed by later reads.
+func (h *Handler) CreateSubscription(c *gin.Context) {
+	var req SubscriptionRequest
+	if !bindSubscription(c, &req) {
+		return
+	}
+
+	sub, err := h.service.SaveSubscription(c.Request.Context(), req.toSubscription(""))
+	if err != nil {
+		log.Printf("failed to create webhook subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := toSubscriptionResponse(sub)
+	resp.Secret = sub.Secret
+	c.JSON(http.StatusCreated, resp)
+}
+
+// PutSubscription replaces a subscription. The secret is kept unless a new
+// one is given, and a dead-lettered subscription is resumed by setting its
+// status to active.
+func (h *Handler) PutSubscription(c *gin.Context) {
+	var params IDParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	var req SubscriptionRequest
+	if !bindSubscription(c, &req) {
+		return
+	}
+
+	existing, err := h.service.GetSubscription(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to fetch webhook subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "webhook subscription not found",
+		})
+		return
+	}
+
+	sub := req.toSubscription(params.ID)
+	sub.CreatedAt = existing.CreatedAt
+	if sub.Secret == "" {
+		sub.Secret = existing.Secret
+	}
+
+	sub, err = h.service.SaveSubscription(c.Request.Context(), sub)
+	if err != nil {
+		log.Printf("failed to update webhook subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, toSubscriptionResponse(sub))
+}
+
+func (h *Handler) DeleteSubscription(c *gin.Context) {
+	var params IDParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.DeleteSubscription(c.Request.Context(), params.ID); err != nil {
+		log.Printf("failed to delete webhook subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListDeliveries(c *gin.Context) {
+	var params DeliveriesQueryParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	deliveries, err := h.service.ListDeliveries(c.Request.Context(), repository.WebhookDeliveriesFilter{
+		SubscriptionID: params.SubscriptionID,
+		Status:         params.Status,
+		Limit:          params.Limit,
+	})
+	if err != nil {
+		log.Printf("failed to list webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+
+	resp := make([]DeliveryResponse, len(deliveries))
+	for i, d := range deliveries {
+		resp[i] = toDeliveryResponse(d)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetDelivery includes the payload that was sent, unlike the list.
+func (h *Handler) GetDelivery(c *gin.Context) {
+	var params IDParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	delivery, err := h.service.GetDelivery(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to fetch webhook delivery: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if delivery == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "webhook delivery not found",
+		})
+		return
+	}
+
+	resp := toDeliveryResponse(*delivery)
+	resp.Payload = json.RawMessage(delivery.Payload)
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) RetryDelivery(c *gin.Context) {
+	var params IDParams
+	if err := c.ShouldBindUri(&params); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	delivery, err := h.service.RetryDelivery(c.Request.Context(), params.ID)
+	if err != nil {
+		log.Printf("failed to retry webhook delivery: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "internal server error",
+		})
+		return
+	}
+	if delivery == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "webhook delivery not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, toDeliveryResponse(*delivery))
+}
+
+// bindSubscription binds and validates a subscription body, writing the
+// error response itself.
+func bindSubscription(c *gin.Context, req *SubscriptionRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return false
+	}
+	for i := range req.Conditions {
+		if err := req.Conditions[i].validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) RegisterRoutes(r gin.IRoutes) {
+	r.GET("/admin/webhooks/subscriptions", h.ListSubscriptions)
+	r.POST("/admin/webhooks/subscriptions", h.CreateSubscription)
+	r.GET("/admin/webhooks/subscriptions/:id", h.GetSubscription)
+	r.PUT("/admin/webhooks/subscriptions/:id", h.PutSubscription)
+	r.DELETE("/admin/webhooks/subscriptions/:id", h.DeleteSubscription)
+	r.GET("/admin/webhooks/deliveries", h.ListDeliveries)
+	r.GET("/admin/webhooks/deliveries/:id", h.GetDelivery)
+	r.POST("/admin/webhooks/deliveries/:id/retry", h.RetryDelivery)
+}
@@ -0,0 +1,74 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	StatusActive     = "active"
+	StatusPaused     = "paused"
+	StatusDeadLetter = "dead_letter"
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Subscription sends events named EventName, optionally only those from
+// Channel and those whose metadata meets every condition, to URL. Only
+// active subscriptions receive events; a subscription is moved to the dead
+// letter state when one of its deliveries runs out of attempts.
+type Subscription struct {
+	ID         string
+	EventName  string
+	Channel    string
+	Conditions []Condition
+	URL        string
+	Secret     string
+	Status     string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Delivery is one event sent to one subscription, with the outcome of its
+// latest attempt. Pending deliveries are retried at NextAttemptAt by
+// whichever instance runs the retries; Instance is the one that last
+// handled the delivery.
+type Delivery struct {
+	ID             string
+	SubscriptionID string
+	EventName      string
+	EventHash      uint64
+	UserID         string
+	Payload        string
+	Status         string
+	Attempts       uint32
+	NextAttemptAt  time.Time
+	LastStatusCode uint16
+	LastError      string
+	Instance       string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Payload is the body of a webhook request. ID identifies the delivery and
+// stays the same across retries, so receivers can drop duplicates.
+type Payload struct {
+	ID             string       `json:"id"`
+	SubscriptionID string       `json:"subscription_id"`
+	Event          EventPayload `json:"event"`
+}
+
+type EventPayload struct {
+	EventID    string          `json:"event_id,omitempty"`
+	EventName  string          `json:"event_name"`
+	Channel    string          `json:"channel"`
+	CampaignID string          `json:"campaign_id,omitempty"`
+	UserID     string          `json:"user_id"`
+	SessionID  string          `json:"session_id,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Tags       []string        `json:"tags,omitempty"`
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
@@ -0,0 +1,59 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("whsec_test_secret", "1700000000", []byte(`{"event":"purchase"}`))
+	want := "690e4dc90f468724b09f9e8a658e69581e2f1fff34d0e10baa2e74eded8c353e"
+	if got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+
+	if Sign("whsec_test_secret", "1700000001", []byte(`{"event":"purchase"}`)) == want {
+		t.Error("Sign() ignores the timestamp")
+	}
+}
+
+func TestSenderSignsRequests(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	payload := []byte(`{"event":"purchase"}`)
+	status, err := NewSender(time.Second).Send(context.Background(), server.URL, "whsec_test_secret", "delivery-1", payload)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Send() = %d, %v", status, err)
+	}
+
+	if got.Header.Get(IDHeader) != "delivery-1" {
+		t.Errorf("%s = %q", IDHeader, got.Header.Get(IDHeader))
+	}
+	want := "sha256=" + Sign("whsec_test_secret", got.Header.Get(TimestampHeader), body)
+	if got.Header.Get(SignatureHeader) != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got.Header.Get(SignatureHeader), want)
+	}
+}
+
+func TestSenderReportsFailedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	status, err := NewSender(time.Second).Send(context.Background(), server.URL, "secret", "delivery-1", []byte(`{}`))
+	if err == nil || status != http.StatusServiceUnavailable {
+		t.Errorf("Send() = %d, %v, want a 503 error", status, err)
+	}
+}
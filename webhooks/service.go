@@ -0,0 +1,270 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/insider/event-ingestion/clickhouse/repository"
+	"github.com/insider/event-ingestion/config"
+	"github.com/insider/event-ingestion/kafka"
+)
+
+type webhooksRepository interface {
+	ListWebhookSubscriptions(ctx context.Context) ([]repository.WebhookSubscriptionRow, error)
+	GetWebhookSubscription(ctx context.Context, id string) (*repository.WebhookSubscriptionRow, error)
+	SaveWebhookSubscription(ctx context.Context, row repository.WebhookSubscriptionRow) error
+	DeleteWebhookSubscription(ctx context.Context, id string) error
+	SaveWebhookDelivery(ctx context.Context, row repository.WebhookDeliveryRow) error
+	SaveWebhookDeliveries(ctx context.Context, rows []repository.WebhookDeliveryRow) error
+	GetWebhookDelivery(ctx context.Context, id string) (*repository.WebhookDeliveryRow, error)
+	ListWebhookDeliveries(ctx context.Context, filter repository.WebhookDeliveriesFilter) ([]repository.WebhookDeliveryRow, error)
+	ListDueWebhookDeliveries(ctx context.Context, subscriptionIDs []string, now time.Time, limit int) ([]repository.WebhookDeliveryRow, error)
+}
+
+type leaseRepository interface {
+	AcquireLease(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, owner string) error
+}
+
+// Service manages webhook subscriptions and delivers the events published
+// by this instance to them. It is an events.Sink: events are queued in
+// memory and sent by a pool of workers. Every delivery is stored as pending
+// before it is sent, and pending deliveries that are due, such as failed
+// ones waiting out their backoff, are retried by the one instance that
+// holds the retrier lease.
+type Service struct {
+	repo     webhooksRepository
+	leases   leaseRepository
+	sender   *Sender
+	cfg      config.WebhooksConfig
+	instance string
+	owner    string
+
+	subscriptions atomic.Pointer[subscriptionSet]
+	queue         chan kafka.EventMessage
+
+	// Events that arrive while the queue is full wait in overflow until
+	// they are stored as pending deliveries.
+	mu       sync.Mutex
+	overflow []kafka.EventMessage
+	spill    chan struct{}
+	dropped  atomic.Uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// subscriptionSet holds the active subscriptions, with their event names
+// indexed so that Send can skip unwanted events cheaply.
+type subscriptionSet struct {
+	list       []Subscription
+	eventNames map[string]struct{}
+}
+
+func NewService(repo webhooksRepository, leases leaseRepository, sender *Sender, cfg config.WebhooksConfig) *Service {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "localhost"
+	}
+	cfg.Workers = max(cfg.Workers, 1)
+
+	return &Service{
+		repo:     repo,
+		leases:   leases,
+		sender:   sender,
+		cfg:      cfg,
+		instance: instance,
+		owner:    uuid.NewString(),
+		queue:    make(chan kafka.EventMessage, cfg.QueueSize),
+		spill:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *Service) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := s.repo.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+
+	subscriptions := make([]Subscription, 0, len(rows))
+	for _, row := range rows {
+		sub, err := fromSubscriptionRow(row)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions, nil
+}
+
+func (s *Service) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	row, err := s.repo.GetWebhookSubscription(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	if row == nil {
+		return nil, nil
+	}
+
+	sub, err := fromSubscriptionRow(*row)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// SaveSubscription creates the subscription when it has no ID and replaces
+// it otherwise. New subscriptions without a secret get a random one.
+func (s *Service) SaveSubscription(ctx context.Context, sub Subscription) (Subscription, error) {
+	now := time.Now().UTC()
+	if sub.ID == "" {
+		sub.ID = uuid.NewString()
+		sub.CreatedAt = now
+	}
+	if sub.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return Subscription{}, err
+		}
+		sub.Secret = secret
+	}
+	sub.UpdatedAt = now
+
+	row, err := toSubscriptionRow(sub)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if err := s.repo.SaveWebhookSubscription(ctx, row); err != nil {
+		return Subscription{}, fmt.Errorf("failed to save webhook subscription: %w", err)
+	}
+
+	s.refreshAfterChange(ctx)
+	return sub, nil
+}
+
+func (s *Service) DeleteSubscription(ctx context.Context, id string) error {
+	if err := s.repo.DeleteWebhookSubscription(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+
+	s.refreshAfterChange(ctx)
+	return nil
+}
+
+func (s *Service) ListDeliveries(ctx context.Context, filter repository.WebhookDeliveriesFilter) ([]Delivery, error) {
+	rows, err := s.repo.ListWebhookDeliveries(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	deliveries := make([]Delivery, len(rows))
+	for i, row := range rows {
+		deliveries[i] = Delivery(row)
+	}
+	return deliveries, nil
+}
+
+func (s *Service) GetDelivery(ctx context.Context, id string) (*Delivery, error) {
+	row, err := s.repo.GetWebhookDelivery(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	if row == nil {
+		return nil, nil
+	}
+
+	delivery := Delivery(*row)
+	return &delivery, nil
+}
+
+// RetryDelivery queues a delivery, typically a dead one, to be sent again
+// by the next retry pass with a fresh set of attempts.
+func (s *Service) RetryDelivery(ctx context.Context, id string) (*Delivery, error) {
+	delivery, err := s.GetDelivery(ctx, id)
+	if err != nil || delivery == nil {
+		return delivery, err
+	}
+
+	now := time.Now().UTC()
+	delivery.Status = DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.Instance = s.instance
+	delivery.UpdatedAt = now
+
+	if err := s.repo.SaveWebhookDelivery(ctx, repository.WebhookDeliveryRow(*delivery)); err != nil {
+		return nil, fmt.Errorf("failed to retry webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// refreshAfterChange makes a subscription change take effect on this
+// instance right away; other instances pick it up on their next refresh.
+func (s *Service) refreshAfterChange(ctx context.Context) {
+	if err := s.refresh(ctx); err != nil {
+		log.Printf("failed to refresh webhook subscriptions: %v", err)
+	}
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func fromSubscriptionRow(row repository.WebhookSubscriptionRow) (Subscription, error) {
+	var conditions []Condition
+	if row.Conditions != "" {
+		if err := json.Unmarshal([]byte(row.Conditions), &conditions); err != nil {
+			return Subscription{}, fmt.Errorf("failed to parse conditions of webhook subscription %s: %w", row.ID, err)
+		}
+	}
+
+	return Subscription{
+		ID:         row.ID,
+		EventName:  row.EventName,
+		Channel:    row.Channel,
+		Conditions: conditions,
+		URL:        row.URL,
+		Secret:     row.Secret,
+		Status:     row.Status,
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt,
+	}, nil
+}
+
+func toSubscriptionRow(sub Subscription) (repository.WebhookSubscriptionRow, error) {
+	var conditions []byte
+	if len(sub.Conditions) > 0 {
+		var err error
+		conditions, err = json.Marshal(sub.Conditions)
+		if err != nil {
+			return repository.WebhookSubscriptionRow{}, fmt.Errorf("failed to marshal conditions: %w", err)
+		}
+	}
+
+	return repository.WebhookSubscriptionRow{
+		ID:         sub.ID,
+		EventName:  sub.EventName,
+		Channel:    sub.Channel,
+		Conditions: string(conditions),
+		URL:        sub.URL,
+		Secret:     sub.Secret,
+		Status:     sub.Status,
+		CreatedAt:  sub.CreatedAt,
+		UpdatedAt:  sub.UpdatedAt,
+	}, nil
+}